@@ -0,0 +1,446 @@
+// Command lozenge works with lozenge templates from the command line.
+//
+//	lozenge generate [flags] file.◊   generate Go code for the template
+//	lozenge tokens [flags] file.◊     print the template's tokens as JSON
+//	lozenge ast [flags] file.◊        print the template's parse tree as JSON
+//	lozenge cover [flags] file.◊      report the template's test coverage
+//	lozenge fmt [flags] files...      format the Go code in templates
+//	lozenge vet [flags] files...      report likely mistakes in templates
+//
+// Errors in templates are shown with ANSI colours when writing to a terminal
+// and in plain ASCII otherwise. Pass -errors=unicode, ascii, ansi or compact
+// to choose for yourself, where compact gives file:line:col: message lines
+// for editors. The NO_COLOR environment variable turns the colours off.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/tools/cover"
+
+	"github.com/BestFriendChris/lozenge_template"
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/coverage"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/formatter"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/sourcemap"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/suggest"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+type command struct {
+	summary string
+	// run is told whether stderr is a terminal, for -errors=auto to go by
+	run func(args []string, stdout io.Writer, stderrIsTerminal bool) error
+}
+
+var commands = map[string]command{
+	"generate": {"generate Go code for the template", runGenerate},
+	"tokens":   {"print the template's tokens as JSON", runTokens},
+	"ast":      {"print the template's parse tree as JSON", runAST},
+	"cover":    {"report the template's test coverage", runCover},
+	"fmt":      {"format the Go code in templates", runFmt},
+	"vet":      {"report likely mistakes in templates", runVet},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, found := commands[args[0]]
+	if !found {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		if closest := suggest.Closest(args[0], names); len(closest) > 0 {
+			_, _ = fmt.Fprintf(stderr, "lozenge: unknown command %q; did you mean %q?\n", args[0], closest[0])
+		} else {
+			_, _ = fmt.Fprintf(stderr, "lozenge: unknown command %q\n", args[0])
+		}
+		usage(stderr)
+		return 2
+	}
+	if err := cmd.run(args[1:], stdout, isTerminal(stderr)); err != nil {
+		if te, found := errors.Find(err); found {
+			if _, compact := te.Renderer.(errors.CompactRenderer); compact {
+				// Left without the command's name, or any prefix added while
+				// the error was passed up, for editors to read
+				_, _ = fmt.Fprintln(stderr, te.Error())
+				return 1
+			}
+		}
+		_, _ = fmt.Fprintf(stderr, "lozenge %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "usage: lozenge <command> [flags] file.◊")
+	_, _ = fmt.Fprintln(w, "commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+// templateFlags are the flags shared by every command reading a template,
+// along with whether stderr is a terminal for -errors=auto to go by
+type templateFlags struct {
+	marker   string
+	trim     bool
+	tabWidth int
+	context  int
+	errors   string
+
+	stderrIsTerminal bool
+}
+
+func (tf *templateFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.marker, "marker", "◊", "the character starting template code")
+	fs.BoolVar(&tf.trim, "trim", false, "trim spaces around code blocks")
+	fs.IntVar(&tf.tabWidth, "tabwidth", column.DefaultTabWidth, "the width of a tab when showing where errors are")
+	fs.IntVar(&tf.context, "context", errors.DefaultContext, "the number of lines to show either side of errors")
+	fs.StringVar(&tf.errors, "errors", "auto", "how to show errors: auto, ansi, unicode, ascii or compact")
+}
+
+func (tf *templateFlags) config() (lozenge_template.ParserConfig, error) {
+	config := lozenge_template.NewParserConfig()
+	r, size := utf8.DecodeRuneInString(tf.marker)
+	if r == utf8.RuneError || size != len(tf.marker) {
+		return config, fmt.Errorf("marker must be a single character, got %q", tf.marker)
+	}
+	config = config.WithMarker(r)
+	if tf.trim {
+		config = config.WithTrimSpaces()
+	}
+	return config, nil
+}
+
+// parseArgs parses the flags for a command taking a single template file,
+// returning the template ready to use along with its input. extraFlags may
+// register flags of the command's own.
+func parseArgs(name string, args []string, stderrIsTerminal bool, extraFlags func(fs *flag.FlagSet)) (*lozenge_template.LozengeTemplate, *input.Input, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tf := templateFlags{stderrIsTerminal: stderrIsTerminal}
+	tf.register(fs)
+	if extraFlags != nil {
+		extraFlags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, nil, fmt.Errorf("expected a single template file")
+	}
+	config, err := tf.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := tf.readInput(fs.Arg(0))
+	if err != nil {
+		return nil, nil, err
+	}
+	return lozenge_template.New(nil, config), in, nil
+}
+
+func (tf *templateFlags) readInput(fname string) (*input.Input, error) {
+	renderer, err := tf.renderer()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fname)
+	if os.IsNotExist(err) {
+		if closest := closestFile(fname); closest != "" {
+			return nil, fmt.Errorf("%w; did you mean %q?", err, closest)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	in := input.NewInput(fname, string(b))
+	in.SetTabWidth(tf.tabWidth)
+	in.SetErrorContext(tf.context)
+	in.SetErrorRenderer(renderer)
+	return in, nil
+}
+
+// closestFile returns the file next to fname whose name is closest to it, or
+// "" if none are close enough to suggest
+func closestFile(fname string) string {
+	dir := filepath.Dir(fname)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	closest := suggest.Closest(filepath.Base(fname), names)
+	if len(closest) == 0 {
+		return ""
+	}
+	return filepath.Join(dir, closest[0])
+}
+
+func (tf *templateFlags) renderer() (errors.Renderer, error) {
+	switch tf.errors {
+	case "auto":
+		if !tf.stderrIsTerminal {
+			return errors.SnippetRenderer{ASCII: true}, nil
+		}
+		// See https://no-color.org
+		return errors.SnippetRenderer{Color: os.Getenv("NO_COLOR") == ""}, nil
+	case "ansi":
+		return errors.SnippetRenderer{Color: true}, nil
+	case "unicode":
+		return errors.SnippetRenderer{}, nil
+	case "ascii":
+		return errors.SnippetRenderer{ASCII: true}, nil
+	case "compact":
+		return errors.CompactRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -errors %q", tf.errors)
+	}
+}
+
+// isTerminal reports whether w is a terminal rather than a file or pipe
+func isTerminal(w io.Writer) bool {
+	f, isFile := w.(*os.File)
+	if !isFile {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func runGenerate(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	var out, mapOut string
+	lt, in, err := parseArgs("generate", args, stderrIsTerminal, func(fs *flag.FlagSet) {
+		fs.StringVar(&out, "o", "", "write the Go code to this file instead of stdout")
+		fs.StringVar(&mapOut, "map", "", "also write a JSON source map to this file")
+	})
+	if err != nil {
+		return err
+	}
+	var goCode string
+	var sm *sourcemap.SourceMap
+	if mapOut == "" {
+		goCode, err = lt.Generate(&main_handler.MainHandler{}, in)
+	} else {
+		goCode, sm, err = lt.GenerateWithSourceMap(&main_handler.MainHandler{}, in)
+	}
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = io.WriteString(stdout, goCode)
+	} else {
+		err = os.WriteFile(out, []byte(goCode), 0644)
+	}
+	if err != nil || sm == nil {
+		return err
+	}
+	sm.File = out
+	var buf bytes.Buffer
+	if err = sm.WriteJSON(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(mapOut, buf.Bytes(), 0644)
+}
+
+func runFmt(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tf := templateFlags{stderrIsTerminal: stderrIsTerminal}
+	tf.register(fs)
+	list := fs.Bool("l", false, "list files whose formatting differs instead of rewriting them")
+	showDiff := fs.Bool("d", false, "print diffs instead of rewriting files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("expected template files")
+	}
+	config, err := tf.config()
+	if err != nil {
+		return err
+	}
+	lt := lozenge_template.New(nil, config)
+	var unformatted int
+	for _, fname := range fs.Args() {
+		in, err := tf.readInput(fname)
+		if err != nil {
+			return err
+		}
+		src := in.Rest()
+		toks, err := lt.Tokenize(&main_handler.MainHandler{}, in)
+		if err != nil {
+			return err
+		}
+		formatted := formatter.Format(src, toks)
+		if formatted == src {
+			continue
+		}
+		unformatted++
+		switch {
+		case *list:
+			_, err = fmt.Fprintln(stdout, fname)
+		case *showDiff:
+			err = difflib.WriteUnifiedDiff(stdout, difflib.UnifiedDiff{
+				A:        difflib.SplitLines(src),
+				B:        difflib.SplitLines(formatted),
+				FromFile: fname + ".orig",
+				ToFile:   fname,
+				Context:  3,
+			})
+		default:
+			err = os.WriteFile(fname, []byte(formatted), 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Let CI fail when anything needs formatting
+	if (*list || *showDiff) && unformatted > 0 {
+		return fmt.Errorf("%d of %d files need formatting", unformatted, fs.NArg())
+	}
+	return nil
+}
+
+func runVet(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	fs := flag.NewFlagSet("vet", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tf := templateFlags{stderrIsTerminal: stderrIsTerminal}
+	tf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("expected template files")
+	}
+	config, err := tf.config()
+	if err != nil {
+		return err
+	}
+	lt := lozenge_template.New(nil, config)
+	var problems int
+	for _, fname := range fs.Args() {
+		in, err := tf.readInput(fname)
+		if err != nil {
+			return err
+		}
+		diags, err := lt.Vet(&main_handler.MainHandler{}, in, nil)
+		if err != nil {
+			return err
+		}
+		for _, d := range diags {
+			_, err = fmt.Fprintf(stdout, "%s:%d:%d: %s (%s)\n", fname, d.Pos.Row, d.Pos.Col, d.Message, d.Rule)
+			if err != nil {
+				return err
+			}
+		}
+		problems += len(diags)
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+	return nil
+}
+
+func runTokens(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	lt, in, err := parseArgs("tokens", args, stderrIsTerminal, nil)
+	if err != nil {
+		return err
+	}
+	toks, err := lt.Tokenize(&main_handler.MainHandler{}, in)
+	if err != nil {
+		return err
+	}
+	return token.FprintJSON(stdout, lt.TokenTypes(), toks)
+}
+
+func runAST(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	lt, in, err := parseArgs("ast", args, stderrIsTerminal, nil)
+	if err != nil {
+		return err
+	}
+	nodes, err := lt.ParseTree(&main_handler.MainHandler{}, in)
+	if err != nil {
+		return err
+	}
+	return ast.FprintJSON(stdout, nodes)
+}
+
+func runCover(args []string, stdout io.Writer, stderrIsTerminal bool) error {
+	var profileFname, mapFname, htmlOut string
+	lt, in, err := parseArgs("cover", args, stderrIsTerminal, func(fs *flag.FlagSet) {
+		fs.StringVar(&profileFname, "profile", "", "the coverprofile written by go test")
+		fs.StringVar(&mapFname, "map", "", "the source map written by lozenge generate")
+		fs.StringVar(&htmlOut, "html", "", "also write an HTML report to this file")
+	})
+	if err != nil {
+		return err
+	}
+	if profileFname == "" || mapFname == "" {
+		return fmt.Errorf("both -profile and -map are required")
+	}
+	profiles, err := cover.ParseProfiles(profileFname)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(mapFname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sm, err := sourcemap.ReadJSON(f)
+	if err != nil {
+		return err
+	}
+	// Parsing consumes in, so the text is taken first
+	src := in.RestSlice()
+	nodes, err := lt.ParseTree(&main_handler.MainHandler{}, in)
+	if err != nil {
+		return err
+	}
+	report, err := coverage.New(src, nodes, sm, profiles)
+	if err != nil {
+		return err
+	}
+	if htmlOut != "" {
+		var buf bytes.Buffer
+		if err = report.WriteHTML(&buf); err != nil {
+			return err
+		}
+		if err = os.WriteFile(htmlOut, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return report.WriteText(stdout)
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("tokens", func(t *testing.T) {
+		fname := writeTemplate(t, "hi ◊name")
+		code, stdout, stderr := runWithArgs("tokens", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stdout")
+		c.Print(stdout)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 0
+			################################################################################
+			# stdout
+			################################################################################
+			[
+			  {
+			    "type": "TT.Content",
+			    "text": "hi ",
+			    "start": {
+			      "offset": 0,
+			      "line": 1,
+			      "col": 1,
+			      "runeCol": 1,
+			      "displayCol": 1
+			    },
+			    "end": {
+			      "offset": 3,
+			      "line": 1,
+			      "col": 4,
+			      "runeCol": 4,
+			      "displayCol": 4
+			    }
+			  },
+			  {
+			    "type": "TT.CodeLocalExpr",
+			    "text": "name",
+			    "start": {
+			      "offset": 6,
+			      "line": 1,
+			      "col": 7,
+			      "runeCol": 5,
+			      "displayCol": 5
+			    },
+			    "end": {
+			      "offset": 10,
+			      "line": 1,
+			      "col": 11,
+			      "runeCol": 9,
+			      "displayCol": 9
+			    }
+			  }
+			]
+			################################################################################
+			# stderr
+			################################################################################
+			`)
+	})
+	t.Run("generate with source map", func(t *testing.T) {
+		fname := writeTemplate(t, "hi ◊name")
+		dir := filepath.Dir(fname)
+		goFname := filepath.Join(dir, "test.go")
+		mapFname := filepath.Join(dir, "test.map.json")
+		code, stdout, stderr := runWithArgs("generate", "-o", goFname, "-map", mapFname, fname)
+
+		goCode, _ := os.ReadFile(goFname)
+		var sm struct {
+			File     string
+			Mappings []json.RawMessage
+		}
+		b, _ := os.ReadFile(mapFname)
+		err := json.Unmarshal(b, &sm)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PVWN("stdout", stdout)
+		c.PVWN("stderr", stderr)
+		c.PrintSection("go")
+		c.Print(strings.ReplaceAll(string(goCode), dir, "$DIR"))
+		c.PrintSection("source map")
+		c.PVWN("file is -o", sm.File == goFname)
+		c.PVWN("mappings", len(sm.Mappings))
+		c.PVWN("err", err)
+		c.Expect(`
+			code: 0
+			stdout: ""
+			stderr: ""
+			################################################################################
+			# go
+			################################################################################
+			// Code generated by lozenge_template; DO NOT EDIT.
+			package main
+			
+			import (
+				"bytes"
+				"fmt"
+			)
+			
+			func main() {
+				buf := new(bytes.Buffer)
+			//line $DIR/test.◊:1
+				buf.WriteString("hi ")
+			//line $DIR/test.◊:1
+				buf.WriteString(fmt.Sprintf("%v", name))
+				fmt.Print(buf.String())
+			}
+			################################################################################
+			# source map
+			################################################################################
+			file is -o: true
+			mappings: 2
+			err: <nil>
+			`)
+	})
+	t.Run("ast", func(t *testing.T) {
+		fname := writeTemplate(t, "◊.for _, v := range vals {◊◊v◊}")
+		code, stdout, stderr := runWithArgs("ast", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stdout")
+		c.Print(stdout)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 0
+			################################################################################
+			# stdout
+			################################################################################
+			[
+			  {
+			    "kind": "For",
+			    "text": "for _, v := range vals {",
+			    "vars": [
+			      "v"
+			    ],
+			    "start": {
+			      "offset": 4,
+			      "line": 1,
+			      "col": 5,
+			      "runeCol": 3,
+			      "displayCol": 3
+			    },
+			    "end": {
+			      "offset": 39,
+			      "line": 1,
+			      "col": 40,
+			      "runeCol": 32,
+			      "displayCol": 32
+			    },
+			    "children": [
+			      {
+			        "kind": "Expr",
+			        "text": "v",
+			        "start": {
+			          "offset": 34,
+			          "line": 1,
+			          "col": 35,
+			          "runeCol": 29,
+			          "displayCol": 29
+			        },
+			        "end": {
+			          "offset": 35,
+			          "line": 1,
+			          "col": 36,
+			          "runeCol": 30,
+			          "displayCol": 30
+			        }
+			      }
+			    ]
+			  }
+			]
+			################################################################################
+			# stderr
+			################################################################################
+			`)
+	})
+}
+
+func TestRun_fmt(t *testing.T) {
+	const unformatted = "◊{x:=1}\n◊.if x>1 {◊big◊}  else {◊small◊}\n"
+	t.Run("rewrite", func(t *testing.T) {
+		fname := writeTemplate(t, unformatted)
+		code, stdout, stderr := runWithArgs("fmt", fname)
+		got, _ := os.ReadFile(fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PVWN("stdout", stdout)
+		c.PVWN("stderr", stderr)
+		c.PrintSection("file")
+		c.Print(string(got))
+		c.Expect(`
+			code: 0
+			stdout: ""
+			stderr: ""
+			################################################################################
+			# file
+			################################################################################
+			◊{ x := 1 }
+			◊.if x > 1 {◊big◊} else {◊small◊}
+			`)
+	})
+	t.Run("list", func(t *testing.T) {
+		fname := writeTemplate(t, unformatted)
+		formattedFname := filepath.Join(filepath.Dir(fname), "formatted.◊")
+		writeFile(t, formattedFname, "◊{ x := 1 }\n")
+		code, stdout, stderr := runWithArgs("fmt", "-l", fname, formattedFname)
+		got, _ := os.ReadFile(fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PVWN("unchanged", string(got) == unformatted)
+		c.PrintSection("stdout")
+		c.Print(strings.ReplaceAll(stdout, filepath.Dir(fname), "$DIR"))
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 1
+			unchanged: true
+			################################################################################
+			# stdout
+			################################################################################
+			$DIR/test.◊
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge fmt: 1 of 2 files need formatting
+			`)
+	})
+	t.Run("diff", func(t *testing.T) {
+		fname := writeTemplate(t, unformatted)
+		code, stdout, _ := runWithArgs("fmt", "-d", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stdout")
+		c.Print(strings.ReplaceAll(stdout, filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stdout
+			################################################################################
+			--- $DIR/test.◊.orig
+			+++ $DIR/test.◊
+			@@ -1,3 +1,3 @@
+			-◊{x:=1}
+			-◊.if x>1 {◊big◊}  else {◊small◊}
+			+◊{ x := 1 }
+			+◊.if x > 1 {◊big◊} else {◊small◊}
+			 
+			`)
+	})
+}
+
+func TestRun_vet(t *testing.T) {
+	fname := writeTemplate(t, "◊.for i, v := range vals {◊\n  <a href=\"◊v\">◊ link</a>\n◊}\n")
+	cleanFname := filepath.Join(filepath.Dir(fname), "clean.◊")
+	writeFile(t, cleanFname, "◊.for _, v := range vals {◊◊v◊}\n")
+	code, stdout, stderr := runWithArgs("vet", fname, cleanFname)
+
+	c := ic.New(t)
+	c.PVWN("code", code)
+	c.PrintSection("stdout")
+	c.Print(strings.ReplaceAll(stdout, filepath.Dir(fname), "$DIR"))
+	c.PrintSection("stderr")
+	c.Print(stderr)
+	c.Expect(`
+		code: 1
+		################################################################################
+		# stdout
+		################################################################################
+		$DIR/test.◊:1:5: loop variable "i" is never used in the loop body (loopvar)
+		$DIR/test.◊:2:15: expression "v" is written into an HTML attribute without escaping (htmlattr)
+		$DIR/test.◊:2:18: "◊" is followed by nothing and written as-is; use "◊◊" for a literal one (straymarker)
+		################################################################################
+		# stderr
+		################################################################################
+		lozenge vet: found 3 problems
+		`)
+}
+
+func TestRun_cover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+	fname := writeTemplate(t, `◊{ n := 0 }
+◊.if n > 0 {◊
+  positive
+◊} else {◊
+  zero
+◊}
+◊.for i := 0; i < n; i++ {◊
+  item ◊i
+◊}
+done`)
+	dir := filepath.Dir(fname)
+	code, _, stderr := runWithArgs("generate", "-trim", "-o", filepath.Join(dir, "page.go"), "-map", filepath.Join(dir, "page.map.json"), fname)
+	if code != 0 {
+		t.Fatalf("generate failed: %s", stderr)
+	}
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/page\n\ngo 1.19\n")
+	writeFile(t, filepath.Join(dir, "page_test.go"), "package main\n\nimport \"testing\"\n\nfunc TestMain(t *testing.T) { main() }\n")
+	cmd := exec.Command("go", "test", "-coverprofile=cover.out", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test failed: %s\n%s", err, out)
+	}
+
+	htmlFname := filepath.Join(dir, "cover.html")
+	code, stdout, stderr := runWithArgs("cover", "-trim",
+		"-profile", filepath.Join(dir, "cover.out"),
+		"-map", filepath.Join(dir, "page.map.json"),
+		"-html", htmlFname,
+		fname)
+	_, err := os.Stat(htmlFname)
+
+	c := ic.New(t)
+	c.PVWN("code", code)
+	c.PVWN("stderr", stderr)
+	c.PVWN("wrote html", err == nil)
+	c.PrintSection("stdout")
+	c.Print(strings.ReplaceAll(stdout, dir, "$DIR"))
+	c.Expect(`
+		code: 0
+		stderr: ""
+		wrote html: true
+		################################################################################
+		# stdout
+		################################################################################
+		$DIR/test.◊: 5 of 7 lines covered (71.4%)
+		$DIR/test.◊:2: if branch "if n > 0 {" never ran
+		$DIR/test.◊:7: for body "for i := 0; i < n; i++ {" never ran
+		$DIR/test.◊:3: uncovered
+		$DIR/test.◊:8: uncovered
+		`)
+}
+
+func TestRun_errorCases(t *testing.T) {
+	t.Run("no command", func(t *testing.T) {
+		code, stdout, stderr := runWithArgs()
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stdout")
+		c.Print(stdout)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 2
+			################################################################################
+			# stdout
+			################################################################################
+			################################################################################
+			# stderr
+			################################################################################
+			usage: lozenge <command> [flags] file.◊
+			commands:
+			  ast        print the template's parse tree as JSON
+			  cover      report the template's test coverage
+			  fmt        format the Go code in templates
+			  generate   generate Go code for the template
+			  tokens     print the template's tokens as JSON
+			  vet        report likely mistakes in templates
+			`)
+	})
+	t.Run("unknown command", func(t *testing.T) {
+		code, _, stderr := runWithArgs("bogus")
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 2
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge: unknown command "bogus"
+			usage: lozenge <command> [flags] file.◊
+			commands:
+			  ast        print the template's parse tree as JSON
+			  cover      report the template's test coverage
+			  fmt        format the Go code in templates
+			  generate   generate Go code for the template
+			  tokens     print the template's tokens as JSON
+			  vet        report likely mistakes in templates
+			`)
+	})
+	t.Run("misspelt command", func(t *testing.T) {
+		code, _, stderr := runWithArgs("genrate")
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.SplitAfter(stderr, "\n")[0])
+		c.Expect(`
+			code: 2
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge: unknown command "genrate"; did you mean "generate"?
+			`)
+	})
+	t.Run("misspelt template", func(t *testing.T) {
+		dir := filepath.Dir(writeTemplate(t, "hi"))
+		code, _, stderr := runWithArgs("generate", filepath.Join(dir, "tset.◊"))
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.ReplaceAll(stderr, dir, "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge generate: open $DIR/tset.◊: no such file or directory; did you mean "$DIR/test.◊"?
+			`)
+	})
+	t.Run("bad marker", func(t *testing.T) {
+		fname := writeTemplate(t, "hi")
+		code, _, stderr := runWithArgs("tokens", "-marker", "ab", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge tokens: marker must be a single character, got "ab"
+			`)
+	})
+	t.Run("template error", func(t *testing.T) {
+		fname := writeTemplate(t, "◊.if true {◊hi")
+		code, _, stderr := runWithArgs("ast", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.ReplaceAll(stderr, filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge ast: did not find "◊}"
+			  --> $DIR/test.◊:1:17
+			  |
+			1 | ◊.if true {◊hi
+			  |             ^^
+			  |               - input ended here
+			`)
+	})
+	t.Run("template error with tabs", func(t *testing.T) {
+		fname := writeTemplate(t, "\t◊.if true {◊hi")
+		code, _, stderr := runWithArgs("ast", "-tabwidth", "2", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.ReplaceAll(stderr, filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge ast: did not find "◊}"
+			  --> $DIR/test.◊:1:18
+			  |
+			1 |   ◊.if true {◊hi
+			  |               ^^
+			  |                 - input ended here
+			`)
+	})
+	t.Run("tokenizer error in compact form", func(t *testing.T) {
+		fname := writeTemplate(t, "first\n◊.if true {◊hi")
+		code, _, stderr := runWithArgs("generate", "-errors", "compact", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.ReplaceAll(stderr, filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			$DIR/test.◊:2:17: did not find "◊}"
+			`)
+	})
+	t.Run("parser error in compact form", func(t *testing.T) {
+		fname := writeTemplate(t, "first\na ◊.slot b")
+		code, _, stderr := runWithArgs("generate", "-errors", "compact", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(strings.ReplaceAll(stderr, filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			$DIR/test.◊:2:7: ◊.slot outside of a component
+			`)
+	})
+	t.Run("template error on a terminal", func(t *testing.T) {
+		t.Setenv("NO_COLOR", "1")
+		fname := writeTemplate(t, "first\n◊.if true {◊hi")
+		err := runAST([]string{fname}, &bytes.Buffer{}, true)
+
+		c := ic.New(t)
+		c.Println(strings.ReplaceAll(err.Error(), filepath.Dir(fname), "$DIR"))
+		c.Expect(`
+			did not find "◊}"
+			  ┌─ $DIR/test.◊:2:17
+			  │
+			1 │ first
+			2 │ ◊.if true {◊hi
+			  │             ^^
+			  │               - input ended here
+			`)
+	})
+	t.Run("bad errors form", func(t *testing.T) {
+		fname := writeTemplate(t, "hi")
+		code, _, stderr := runWithArgs("tokens", "-errors", "fancy", fname)
+
+		c := ic.New(t)
+		c.PVWN("code", code)
+		c.PrintSection("stderr")
+		c.Print(stderr)
+		c.Expect(`
+			code: 1
+			################################################################################
+			# stderr
+			################################################################################
+			lozenge tokens: unknown -errors "fancy"
+			`)
+	})
+}
+
+func writeTemplate(t *testing.T, s string) string {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "test.◊")
+	if err := os.WriteFile(fname, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func writeFile(t *testing.T, fname, s string) {
+	t.Helper()
+	if err := os.WriteFile(fname, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runWithArgs(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
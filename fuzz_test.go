@@ -0,0 +1,310 @@
+package lozenge_template
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+// The fuzz targets live here rather than next to the tokenizer so they can
+// use the default macros
+
+var fuzzSeeds = []string{
+	"",
+	"hi\nthere",
+	"◊",
+	"◊◊",
+	"◊ foo",
+	"◊\n",
+	"◊^",
+	"◊^foo",
+	"◊.",
+	"◊foo bar",
+	"◊foo.bar",
+	"◊(1 + 2)foo",
+	"◊{ x := 1 }",
+	"◊{\nx := 1\ny := 2\n}",
+	"◊^{ import \"strings\" }",
+	"◊{ s := \"}\" }",
+	"◊{ r := '}' }",
+	"◊{ s := `}` }",
+	"◊.if x > 1 {◊big◊} else if x > 0 {◊small◊} else {◊none◊}",
+	"◊.for _, v := range vals {◊\n  ◊v\n◊}",
+	"◊.for i := 0; i < 3; i++ {◊◊i◊}",
+	"◊.for _, v := range vals {◊◊v◊} else {◊none◊}",
+	"◊.for[loop] _, v := range vals {◊◊loop.Index◊}",
+	"◊.for _, v := range vals {◊◊v◊.sep {◊, ◊}◊}",
+	"◊.import \"strings\"",
+	"◊.import (\n\"strings\"\nstr \"strconv\"\n)",
+	"◊.unknown",
+	"◊.é",
+	"◊é",
+	"◊{",
+	"◊(",
+	"◊.if x {◊",
+	"◊.for {◊",
+	"◊}",
+	"◊{ x := 1 }\r\n◊x\r\n",
+	"\uFEFF◊x\tdone",
+	"a ◊# note\nb",
+	"◊# note\r\n◊x",
+	"a◊/* ◊( } */b",
+	"◊/*/",
+	"◊/* unterminated",
+	"◊.raw {◊◊x ◊{ }◊}",
+	"◊.raw ##{◊\n◊}#\n◊}##",
+	"◊.raw {◊",
+	"◊.raw x",
+	"◊.define card(t string) {◊<b>◊t</b>◊.slot◊}\n◊.card(\"x\") {◊body◊}◊.card(\"y\")",
+	"◊.define card() {◊",
+	"◊.define card() {◊◊}◊.card(1)",
+	"◊.slot",
+}
+
+func FuzzContentTokenizer_ReadAll(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		in := input.NewInput("fuzz.◊", s)
+		lt := New(nil, NewParserConfig())
+		toks, err := tokenizer.NewDefault(lt.macros(&main_handler.MainHandler{})).ReadAll(in)
+		if err != nil {
+			return
+		}
+		checkTokens(t, in, s, toks)
+		checkGaps(t, s, toks, lt.TokenTypes())
+	})
+}
+
+func FuzzOptimize(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s, false)
+		f.Add(s, true)
+	}
+	f.Fuzz(func(t *testing.T, s string, trimSpaces bool) {
+		in := input.NewInput("fuzz.◊", s)
+		toks, err := tokenizer.NewDefault(New(nil, NewParserConfig()).macros(&main_handler.MainHandler{})).ReadAll(in)
+		if err != nil {
+			return
+		}
+		var before strings.Builder
+		orig := make([]*token.Token, len(toks))
+		for i, tok := range toks {
+			before.WriteString(tok.Slc.S)
+			cp := *tok
+			orig[i] = &cp
+		}
+		toks = tokenizer.Optimize(toks, trimSpaces)
+		checkTokens(t, in, s, toks)
+		checkOptimized(t, s, orig, toks, trimSpaces)
+		if trimSpaces {
+			return
+		}
+		var after strings.Builder
+		for _, tok := range toks {
+			after.WriteString(tok.Slc.S)
+		}
+		if before.String() != after.String() {
+			t.Errorf("optimizing changed the text of the tokens from %q to %q", before.String(), after.String())
+		}
+	})
+}
+
+func FuzzGenerate(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		// Most inputs aren't valid Go, so mostly this checks nothing panics
+		in := input.NewInput("fuzz.◊", s)
+		goCode, err := New(nil, NewParserConfig()).Generate(&main_handler.MainHandler{}, in)
+		streamed, streamErr := New(nil, NewParserConfig()).GenerateFromReader(&main_handler.MainHandler{}, "fuzz.◊", iotest.OneByteReader(strings.NewReader(s)))
+		if (err == nil) != (streamErr == nil) || goCode != streamed {
+			t.Fatalf("generating from a reader gave %q, %v, want %q, %v", streamed, streamErr, goCode, err)
+		}
+	})
+}
+
+// checkTokens checks that each token holds the text of the template between
+// its positions and that the tokens start in order. A macro's head may overlap
+// the macro's own token, as it re-reads its name.
+func checkTokens(t *testing.T, in *input.Input, s string, toks []*token.Token) {
+	t.Helper()
+	var prevStart int
+	for _, tok := range toks {
+		start, end := tok.Slc.Start, tok.Slc.End
+		if start.Idx < prevStart || end.Idx < start.Idx || end.Idx > len(s) {
+			t.Fatalf("token %s at [%d, %d) is out of order after one starting at %d", tok, start.Idx, end.Idx, prevStart)
+		}
+		if s[start.Idx:end.Idx] != tok.Slc.S {
+			t.Fatalf("token %s doesn't match the template text %q", tok, s[start.Idx:end.Idx])
+		}
+		if start != in.PosAt(start.Idx) || end != in.PosAt(end.Idx) {
+			t.Fatalf("token %s has positions %v and %v, want %v and %v", tok, start, end, in.PosAt(start.Idx), in.PosAt(end.Idx))
+		}
+		prevStart = start.Idx
+	}
+}
+
+// blank matches the whitespace a code block drops along with blank lines
+const blank = `[\t\v\f\r \x{85}\x{A0}\p{Z}]`
+
+// gapMarkers holds, for each kind of token, what the tokenizer consumes before
+// and after one as regular expressions. A code block is split into a token
+// for each line that isn't blank, so its braces are consumed before the first
+// and after the last and its newlines before the others.
+var gapMarkers = map[string]struct{ before, after string }{
+	"BOF":        {after: `\x{FEFF}?`},
+	"EOF":        {},
+	"TT.WS":      {},
+	"TT.NL":      {},
+	"TT.Content": {},
+	// A lone marker is content, and escaping one drops the second
+	"marker":           {after: `◊?`},
+	"TT.CodeLocalExpr": {before: `◊`},
+	// Besides lines of code, blocks hold the heads and closing braces of
+	// block macros such as "if x {◊" and "◊}"
+	"TT.CodeLocalBlock": {
+		before: `◊\{(?:` + blank + `*\n)*|\r?\n(?:` + blank + `*\n)*|◊|`,
+		after:  `\r?(?:\n` + blank + `*)*\}|◊|`,
+	},
+	"TT.CodeGlobalBlock": {
+		before: `◊\^\{(?:` + blank + `*\n)*|\r?\n(?:` + blank + `*\n)*`,
+		after:  `\r?(?:\n` + blank + `*)*\}|`,
+	},
+	"TT.Macro":         {before: `◊\.`, after: `[ \t]*`},
+	"raw":              {before: `◊\.`, after: `[ \t]*#*\{◊`},
+	"TT.import.Import": {after: `(?:[ \t]*\n)?`},
+}
+
+// dropped matches the text the tokenizer drops between tokens: comments that
+// don't start a line and code blocks holding nothing but whitespace
+const dropped = `(?:◊#[^\n]*|(?s:◊/\*.*?\*/)|◊\^?\{(?:` + blank + `|\n)*\})*`
+
+// ownLineComments matches comments on lines of their own, which go along
+// with any indent and the newline ending them
+const ownLineComments = `(?:[ \t]*◊#[^\n]*(?:\n|$))*`
+
+// gapRegexps caches the expressions checkGaps builds for each pair of kinds
+var gapRegexps sync.Map
+
+var rawOpen = regexp.MustCompile(`^raw[ \t]*(#*)\{◊`)
+
+// checkGaps checks that the template text the tokens leave out is exactly
+// what the tokenizer consumes between each kind of token and the next, along
+// with any comments. A BOM isn't part of the text, so is left out too.
+func checkGaps(t *testing.T, s string, toks []*token.Token, names *token.Registry) {
+	t.Helper()
+	kind := func(tok *token.Token) string {
+		switch {
+		case tok.TT == token.TTcontent && tok.Slc.S == "◊":
+			return "marker"
+		case tok.TT == token.TTmacro && tok.Slc.S == "raw":
+			return "raw"
+		}
+		return names.Name(tok.TT)
+	}
+	markers := func(k string) (before, after string) {
+		m, found := gapMarkers[k]
+		if !found {
+			t.Fatalf("no markers known for %s", k)
+		}
+		return m.before, m.after
+	}
+	var covered int
+	prev := "BOF"
+	// rawClose is the marker closing the body of an ◊.raw still being read
+	var rawClose string
+	matches := func(gap, next, close string) bool {
+		_, after := markers(prev)
+		before, _ := markers(next)
+		expr := `^(?:` + after + `)` + regexp.QuoteMeta(close)
+		if covered == 0 || s[covered-1] == '\n' {
+			expr += ownLineComments
+		}
+		expr += dropped + `(?:` + before + `)$`
+		re, found := gapRegexps.Load(expr)
+		if !found {
+			re, _ = gapRegexps.LoadOrStore(expr, regexp.MustCompile(expr))
+		}
+		return re.(*regexp.Regexp).MatchString(gap)
+	}
+	check := func(next string, start int) {
+		gap := s[covered:start]
+		var ok bool
+		switch {
+		case rawClose == "":
+			ok = matches(gap, next, "")
+		case prev == "raw":
+			// The body may be empty, leaving nothing between its markers
+			if ok = matches(gap, next, rawClose); ok {
+				rawClose = ""
+			} else {
+				ok = matches(gap, next, "")
+			}
+		case gap == "" && (next == "TT.Content" || next == "marker"):
+			// The next line of the body
+			ok = true
+		default:
+			ok = matches(gap, next, rawClose)
+			rawClose = ""
+		}
+		if !ok {
+			t.Fatalf("text %q between %s and %s isn't what the tokenizer consumes there", gap, prev, next)
+		}
+	}
+	for _, tok := range toks {
+		start, end := tok.Slc.Start.Idx, tok.Slc.End.Idx
+		k := kind(tok)
+		if start >= covered {
+			check(k, start)
+		}
+		if end > covered {
+			covered = end
+			prev = k
+		}
+		if k == "raw" {
+			m := rawOpen.FindStringSubmatch(s[start:])
+			if m == nil {
+				t.Fatalf("◊.raw at %d isn't followed by its opening marker", start)
+			}
+			rawClose = "◊}" + m[1]
+		}
+	}
+	check("EOF", len(s))
+}
+
+// checkOptimized checks that optimizing toks into optimized left out no more
+// of the template, other than the whitespace tokens trimSpaces drops
+func checkOptimized(t *testing.T, s string, toks, optimized []*token.Token, trimSpaces bool) {
+	t.Helper()
+	mark := func(toks []*token.Token, keep func(*token.Token) bool) []bool {
+		covered := make([]bool, len(s))
+		for _, tok := range toks {
+			if keep(tok) {
+				for i := tok.Slc.Start.Idx; i < tok.Slc.End.Idx; i++ {
+					covered[i] = true
+				}
+			}
+		}
+		return covered
+	}
+	all := func(*token.Token) bool { return true }
+	before, after := mark(toks, all), mark(optimized, all)
+	trimmed := mark(toks, func(tok *token.Token) bool {
+		return trimSpaces && (tok.TT == token.TTws || tok.TT == token.TTnl)
+	})
+	for i := range s {
+		if before[i] != after[i] && !(before[i] && trimmed[i]) {
+			t.Fatalf("optimizing changed whether the text at %d, in %q, is part of a token", i, s)
+		}
+	}
+}
@@ -5,13 +5,13 @@ go 1.19
 require (
 	github.com/BestFriendChris/go-ic v0.0.0-20230116235856-1ab0b55a47b2
 	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883
+	github.com/pmezard/go-difflib v1.0.0
+	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4
+	golang.org/x/tools v0.1.12
 	mvdan.cc/gofumpt v0.4.0
 )
 
 require (
 	github.com/google/go-cmp v0.5.8 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sergi/go-diff v1.2.0 // indirect
-	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
-	golang.org/x/tools v0.1.12 // indirect
 )
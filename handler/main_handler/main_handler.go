@@ -12,6 +12,10 @@ type MainHandler struct {
 	Content      []string
 	GlobalCode   []string
 	InlineOutput []string
+	Imports      []input.Slice
+
+	globalSegments []interfaces.Segment
+	inlineSegments []interfaces.Segment
 }
 
 func (th *MainHandler) DefaultMacros() *interfaces.Macros {
@@ -20,32 +24,29 @@ func (th *MainHandler) DefaultMacros() *interfaces.Macros {
 
 func (th *MainHandler) WriteTextContent(slc input.Slice) {
 	th.Content = append(th.Content, slc.String())
-	s := fmt.Sprintf(
-		"%sbuf.WriteString(%q)",
-		locationComment(slc),
-		slc.S,
-	)
-	th.InlineOutput = append(th.InlineOutput, s)
+	th.writeInline(slc, fmt.Sprintf("buf.WriteString(%q)", slc.S))
 }
 
 func (th *MainHandler) WriteCodeLocalExpression(slc input.Slice) {
-	s := fmt.Sprintf(
-		"%sbuf.WriteString(fmt.Sprintf(%q, %s))",
-		locationComment(slc),
-		"%v",
-		slc.S,
-	)
-	th.InlineOutput = append(th.InlineOutput, s)
+	th.writeInline(slc, fmt.Sprintf("buf.WriteString(fmt.Sprintf(%q, %s))", "%v", slc.S))
 }
 
 func (th *MainHandler) WriteCodeLocalBlock(slc input.Slice) {
-	s := fmt.Sprintf("%s%s", locationComment(slc), slc.S)
-	th.InlineOutput = append(th.InlineOutput, s)
+	th.writeInline(slc, slc.S)
 }
 
 func (th *MainHandler) WriteCodeGlobalBlock(slc input.Slice) {
-	s := fmt.Sprintf("%s%s", locationComment(slc), slc.S)
-	th.GlobalCode = append(th.GlobalCode, s)
+	th.GlobalCode = append(th.GlobalCode, locationComment(slc)+slc.S)
+	th.globalSegments = append(th.globalSegments, interfaces.Segment{Src: slc, Code: slc.S})
+}
+
+func (th *MainHandler) writeInline(slc input.Slice, code string) {
+	th.InlineOutput = append(th.InlineOutput, locationComment(slc)+code)
+	th.inlineSegments = append(th.inlineSegments, interfaces.Segment{Src: slc, Code: code})
+}
+
+func (th *MainHandler) WriteImport(slc input.Slice) {
+	th.Imports = append(th.Imports, slc)
 }
 
 var format = `
@@ -53,7 +54,7 @@ var format = `
 package main
 import "bytes"
 import "fmt"
-%s
+%s%s
 func main() {
 	buf := new(bytes.Buffer)
 %s
@@ -64,11 +65,45 @@ func main() {
 func (th *MainHandler) Done() (string, error) {
 	return fmt.Sprintf(
 		format,
+		th.imports(),
 		strings.Join(th.GlobalCode, "\n"),
 		strings.Join(th.InlineOutput, "\n"),
 	), nil
 }
 
+// Segments lists the code generated for each template slice, in the order
+// Done writes it
+func (th *MainHandler) Segments() []interfaces.Segment {
+	segs := th.importSegments()
+	segs = append(segs, th.globalSegments...)
+	return append(segs, th.inlineSegments...)
+}
+
 func locationComment(slc input.Slice) string {
 	return fmt.Sprintf("//line %s:%d\n", slc.Name, slc.Start.Row)
 }
+
+func (th *MainHandler) imports() string {
+	var sb strings.Builder
+	for _, seg := range th.importSegments() {
+		sb.WriteString(fmt.Sprintf("%simport %s\n", locationComment(seg.Src), seg.Code))
+	}
+	return sb.String()
+}
+
+// importSegments merges the requested imports, dropping any spec that was
+// already requested (or is always imported) with the same name and path. The
+// code of each segment is the spec with its whitespace normalised.
+func (th *MainHandler) importSegments() []interfaces.Segment {
+	seen := map[string]bool{`"bytes"`: true, `"fmt"`: true}
+	var segs []interfaces.Segment
+	for _, slc := range th.Imports {
+		spec := strings.Join(strings.Fields(slc.S), " ")
+		if seen[spec] {
+			continue
+		}
+		seen[spec] = true
+		segs = append(segs, interfaces.Segment{Src: slc, Code: spec})
+	}
+	return segs
+}
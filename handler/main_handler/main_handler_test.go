@@ -170,6 +170,75 @@ func Test_WriteCodeGlobalBlock(t *testing.T) {
 		`)
 }
 
+func Test_WriteImport(t *testing.T) {
+	th := MainHandler{}
+	i := input.NewInput("test", "\"strings\"\nstr  \"strings\"\n\"fmt\"\n\"strings\"")
+	th.WriteImport(nextSlice(i, `"strings"`))
+	nextSlice(i, "\n")
+	th.WriteImport(nextSlice(i, `str  "strings"`))
+	nextSlice(i, "\n")
+	th.WriteImport(nextSlice(i, `"fmt"`))
+	nextSlice(i, "\n")
+	th.WriteImport(nextSlice(i, `"strings"`))
+
+	got, _ := th.Done()
+	got = formatCode(t, got)
+
+	c := ic.New(t)
+	c.PrintSection("Formatted code")
+	c.Println(got)
+	c.Expect(`
+		################################################################################
+		# Formatted code
+		################################################################################
+		// Code generated by lozenge_template; DO NOT EDIT.
+		package main
+		
+		import (
+			"bytes"
+			"fmt"
+		//line test:1
+			"strings"
+		//line test:2
+			str "strings"
+		)
+		
+		func main() {
+			buf := new(bytes.Buffer)
+		
+			fmt.Print(buf.String())
+		}
+		
+		`)
+}
+
+func Test_Segments(t *testing.T) {
+	th := MainHandler{}
+	i := input.NewInput("test", "\"strings\"\"strings\" const x = 1 hi x }")
+	th.WriteImport(nextSlice(i, `"strings"`))
+	th.WriteImport(nextSlice(i, `"strings"`))
+	th.WriteCodeGlobalBlock(nextSlice(i, " const x = 1 "))
+	th.WriteTextContent(nextSlice(i, "hi "))
+	th.WriteCodeLocalExpression(nextSlice(i, "x"))
+	th.WriteCodeLocalBlock(nextSlice(i, " }"))
+
+	c := ic.New(t)
+	c.PrintSection("Segments in output order")
+	for _, seg := range th.Segments() {
+		c.Printf("%s => %q\n", seg.Src, seg.Code)
+	}
+	c.Expect(`
+		################################################################################
+		# Segments in output order
+		################################################################################
+		test:1 - "\"strings\"" => "\"strings\""
+		test:1 - " const x = 1 " => " const x = 1 "
+		test:1 - "hi " => "buf.WriteString(\"hi \")"
+		test:1 - "x" => "buf.WriteString(fmt.Sprintf(\"%v\", x))"
+		test:1 - " }" => " }"
+		`)
+}
+
 func formatCode(t *testing.T, s string) string {
 	formatted, err := go_format.Format(s)
 	if err != nil {
@@ -2,76 +2,170 @@ package input
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"sort"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/suggest"
 )
 
 type Input struct {
-	name    string
-	str     string
-	idx     int
-	lineNo  int
-	lineIdx []int
+	name string
+	// str holds the text from offset base onwards. Offsets used by the
+	// methods below, such as idx, always count from the start of the input.
+	str  string
+	base int
+	idx  int
+	// lineIdx holds the offset just past the end of each line from firstLine
+	// onwards, with the end of str ending the last one
+	lineNo    int
+	firstLine int
+	lineIdx   []int
+	// textStart is the offset of the first line's text, just past any BOM
+	textStart   int
+	tabWidth    int
+	errContext  int
+	errRenderer ErrorRenderer
+	// cols holds the columns last worked out, for the next position on the
+	// same line to carry on from
+	cols colCache
+	// stream is set for an Input reading from an io.Reader; r is cleared
+	// once it's exhausted
+	stream bool
+	r      io.Reader
+	err    error
 }
 
 func NewInput(name string, s string) *Input {
-	return &Input{
-		name:    name,
-		str:     s,
-		lineNo:  1,
-		lineIdx: makeLineIdx(s),
+	i := &Input{
+		name:       name,
+		str:        s,
+		lineNo:     1,
+		firstLine:  1,
+		lineIdx:    makeLineIdx(s),
+		tabWidth:   column.DefaultTabWidth,
+		errContext: errors.DefaultContext,
 	}
+	i.skipBOM()
+	return i
 }
 
+// SetTabWidth sets the width of a tab when working out display columns. It
+// defaults to column.DefaultTabWidth.
+func (i *Input) SetTabWidth(n int) {
+	if n < 1 {
+		n = column.DefaultTabWidth
+	}
+	i.tabWidth = n
+	i.cols = colCache{}
+}
+
+func (i *Input) TabWidth() int {
+	return i.tabWidth
+}
+
+// SetErrorContext sets how many lines errors show either side of those they
+// point at. It defaults to errors.DefaultContext.
+func (i *Input) SetErrorContext(n int) {
+	if n < 0 {
+		n = 0
+	}
+	i.errContext = n
+}
+
+// ErrorRenderer turns an error pointing into a template into text
+type ErrorRenderer = errors.Renderer
+
+// SetErrorRenderer sets how errors are shown. They're shown as a snippet of
+// the template by default.
+func (i *Input) SetErrorRenderer(r ErrorRenderer) {
+	i.errRenderer = r
+}
+
+// skipBOM starts the input after its BOM, if it has one. The BOM is still
+// counted in byte offsets, but isn't part of the text.
+func (i *Input) skipBOM() {
+	if i.HasPrefix(column.BOM) {
+		i.textStart = len(column.BOM)
+		i.idx = i.textStart
+	}
+}
+
+// The methods below panic when misused, e.g. when seeking past the end of
+// the input. Each has a Try variant returning an error instead, for callers
+// such as macros that may be handed input they don't expect.
+
 func (i *Input) Seek(idx int) {
+	if err := i.TrySeek(idx); err != nil {
+		panic(err)
+	}
+}
+
+func (i *Input) TrySeek(idx int) error {
 	if idx < 0 {
-		panic("unable to seek to negative idx")
+		return fmt.Errorf("unable to seek to negative idx")
 	}
-	strLen := len(i.str)
-	if idx > strLen {
-		panic(fmt.Sprintf("unable to seek past end of Input: %d vs %d", idx, strLen))
+	if idx < i.base {
+		return fmt.Errorf("unable to seek to %d, before the released text ending at %d", idx, i.base)
+	}
+	i.ensure(idx)
+	if idx > i.end() {
+		return fmt.Errorf("unable to seek past end of Input: %d vs %d", idx, i.end())
 	}
 	if i.idx+1 == idx {
 		// Fast pass for moving to next character
-		if i.str[i.idx] == '\n' {
+		if i.str[i.idx-i.base] == '\n' {
 			i.lineNo++
 		}
 		i.idx = idx
-		return
 	} else {
-		for lineNo, endIdx := range i.lineIdx {
-			if idx <= endIdx {
-				i.lineNo = lineNo + 1
-				i.idx = idx
-				return
-			}
-		}
+		i.lineNo = i.findLine(idx)
+		i.idx = idx
 	}
-	panic(fmt.Sprintf("unable to find line for idx %d", idx))
+	return nil
 }
 
 func (i *Input) SeekOffset(offset int) {
 	i.Seek(i.idx + offset)
 }
 
+func (i *Input) TrySeekOffset(offset int) error {
+	return i.TrySeek(i.idx + offset)
+}
+
+// Rest returns the text after the current position. For an Input reading
+// from an io.Reader this reads all that's left.
 func (i *Input) Rest() string {
-	return i.str[i.idx:]
+	i.ensureAll()
+	return i.str[i.idx-i.base:]
 }
 
 func (i *Input) RestSlice() Slice {
-	return i.SliceAt(i.idx, len(i.str))
+	i.ensureAll()
+	return i.SliceAt(i.idx, i.end())
+}
+
+// Len returns the length of the whole input in bytes. For an Input reading
+// from an io.Reader this reads all that's left.
+func (i *Input) Len() int {
+	i.ensureAll()
+	return i.end()
 }
 
 func (i *Input) Pos() Pos {
-	return Pos{i.idx, i.lineNo, i.col()}
+	return i.posOnLine(i.idx, i.lineNo)
 }
 
 func (i *Input) PosAt(idx int) Pos {
-	line, col := i.findLineAndCol(idx)
-	return Pos{idx, line, col}
+	i.ensure(idx)
+	if idx < i.base || idx > i.end() {
+		panic(fmt.Sprintf("unable to find line for idx %d", idx))
+	}
+	return i.posOnLine(idx, i.findLine(idx))
 }
 
 func (i *Input) SliceOffset(offset int) Slice {
@@ -85,11 +179,24 @@ func (i *Input) SliceOffset(offset int) Slice {
 }
 
 func (i *Input) SliceAt(from, to int) Slice {
-	return NewSlice(i.name, i.str[from:to], i.PosAt(from), i.PosAt(to))
+	slc, err := i.TrySliceAt(from, to)
+	if err != nil {
+		panic(err)
+	}
+	return slc
+}
+
+func (i *Input) TrySliceAt(from, to int) (Slice, error) {
+	i.ensure(to)
+	if from < i.base || to > i.end() || from > to {
+		return EmptySlice(), fmt.Errorf("unable to slice [%d, %d) from Input holding [%d, %d)", from, to, i.base, i.end())
+	}
+	return NewSlice(i.name, i.text(from, to), i.PosAt(from), i.PosAt(to)), nil
 }
 
 func (i *Input) Consumed() bool {
-	return i.idx >= len(i.str)
+	i.ensure(i.idx + 1)
+	return i.idx >= i.end()
 }
 
 func (i *Input) Consume(r rune) bool {
@@ -113,7 +220,7 @@ func (i *Input) ConsumeString(prefix string) (Slice, bool) {
 }
 
 func (i *Input) ConsumeRegexp(r *regexp.Regexp) (Slice, bool) {
-	found := r.FindIndex([]byte(i.Rest()))
+	found := r.FindStringIndex(i.lookahead())
 	if found != nil && found[0] == 0 {
 		match := i.SliceOffset(found[1])
 		i.SeekOffset(found[1])
@@ -124,17 +231,19 @@ func (i *Input) ConsumeRegexp(r *regexp.Regexp) (Slice, bool) {
 }
 
 func (i *Input) HasPrefix(prefix string) bool {
-	return strings.HasPrefix(i.Rest(), prefix)
+	i.ensure(i.idx + len(prefix))
+	return strings.HasPrefix(i.str[i.idx-i.base:], prefix)
 }
 
 func (i *Input) HasPrefixRegexp(r *regexp.Regexp) bool {
-	found := r.FindIndex([]byte(i.Rest()))
+	found := r.FindStringIndex(i.lookahead())
 	return found != nil && found[0] == 0
 }
 
 func (i *Input) Peek() (r rune, found bool) {
 	var size int
-	r, size = utf8.DecodeRuneInString(i.Rest())
+	i.ensure(i.idx + utf8.UTFMax)
+	r, size = utf8.DecodeRuneInString(i.str[i.idx-i.base:])
 	if size == 0 {
 		return utf8.RuneError, false
 	}
@@ -145,39 +254,110 @@ func (i *Input) Shift(expected rune) {
 	i.ShiftSlice(expected)
 }
 
+func (i *Input) TryShift(expected rune) error {
+	_, err := i.TryShiftSlice(expected)
+	return err
+}
+
 func (i *Input) ShiftSlice(expected rune) Slice {
-	r, found := i.Peek()
-	if !found {
-		panic("nothing to skip")
+	s, err := i.TryShiftSlice(expected)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (i *Input) TryShiftSlice(expected rune) (Slice, error) {
+	i.ensure(i.idx + utf8.UTFMax)
+	r, size := utf8.DecodeRuneInString(i.str[i.idx-i.base:])
+	if size == 0 {
+		return EmptySlice(), fmt.Errorf("nothing to skip")
 	}
 	if r != expected {
-		panic(fmt.Sprintf("unable to skip '%c' (found '%c')", expected, r))
+		return EmptySlice(), fmt.Errorf("unable to skip '%c' (found '%c')", expected, r)
 	}
-	s := i.SliceOffset(1)
-	i.SeekOffset(utf8.RuneLen(r))
-	return s
+	// Invalid UTF-8 decodes as a single byte RuneError
+	s := i.SliceOffset(size)
+	i.SeekOffset(size)
+	return s, nil
 }
 
 func (i *Input) Unshift(expected rune) {
 	i.UnshiftString(string(expected))
 }
 
+func (i *Input) TryUnshift(expected rune) error {
+	return i.TryUnshiftString(string(expected))
+}
+
 func (i *Input) UnshiftString(expected string) {
-	if !strings.HasSuffix(i.str[:i.idx], expected) {
-		panic(fmt.Sprintf("unable to unshift %q: (found %q)", expected, i.str[i.idx-(len(expected)):i.idx]))
+	if err := i.TryUnshiftString(expected); err != nil {
+		panic(err)
+	}
+}
+
+func (i *Input) TryUnshiftString(expected string) error {
+	if !strings.HasSuffix(i.str[:i.idx-i.base], expected) {
+		return fmt.Errorf("unable to unshift %q: (found %q)", expected, i.before(len(expected)))
 	}
 	i.SeekOffset(-len(expected))
+	return nil
 }
 
 func (i *Input) UnshiftSlice(expected Slice) {
-	if !strings.HasSuffix(i.str[:i.idx], expected.S) {
-		panic(fmt.Sprintf("unable to unshift %q: (found %q)", expected, i.str[i.idx-(expected.Len()):i.idx]))
+	if err := i.TryUnshiftSlice(expected); err != nil {
+		panic(err)
 	}
-	i.SeekOffset(-expected.Len())
 }
 
+func (i *Input) TryUnshiftSlice(expected Slice) error {
+	if !strings.HasSuffix(i.str[:i.idx-i.base], expected.S) {
+		return fmt.Errorf("unable to unshift %q: (found %q)", expected, i.before(len(expected.S)))
+	}
+	i.SeekOffset(-len(expected.S))
+	return nil
+}
+
+// before returns up to n bytes preceding the current position
+func (i *Input) before(n int) string {
+	if n > i.idx-i.base {
+		n = i.idx - i.base
+	}
+	return i.text(i.idx-n, i.idx)
+}
+
+// Label marks the template text between byte offsets From and To for an
+// error, along with a message saying what's there
+type Label = errors.Label
+
 func (i *Input) ErrorHere(err error) error {
-	return errors.NewTokenizerError(i.str, i.idx, err)
+	return i.ErrorAt(i.idx, i.idx, err)
+}
+
+// ErrorAt returns err pointing at the text between offsets from and to, with
+// labels marking any related places. Text that's been released can't be
+// shown, so anything pointing there points at the first text held instead.
+func (i *Input) ErrorAt(from, to int, err error, labels ...Label) error {
+	return i.errorAt(from, to, err, labels...)
+}
+
+// UnknownError returns an error for slc naming a kind of thing, such as a
+// macro, that isn't one of known. The known names closest to it are
+// suggested in its place.
+func (i *Input) UnknownError(kind string, slc Slice, known []string) error {
+	e := i.errorAt(slc.Start.Idx, slc.End.Idx, fmt.Errorf("unknown %s %q", kind, slc.S))
+	for _, name := range suggest.Closest(slc.S, known) {
+		e.Suggestions = append(e.Suggestions, errors.Suggestion{From: slc.Start.Idx, To: slc.End.Idx, Text: name})
+	}
+	return e
+}
+
+func (i *Input) errorAt(from, to int, err error, labels ...Label) *errors.TokenizerError {
+	// The released text always ends at the start of a line
+	src := errors.Source{Name: i.name, Text: i.str, Base: i.base, FirstLine: i.firstLine, TabWidth: i.tabWidth}
+	e := errors.NewSourceError(src, i.errContext, err, Label{From: from, To: to}, labels...)
+	e.Renderer = i.errRenderer
+	return e
 }
 
 func (i *Input) ReadWhile(f func(r rune) bool) Slice {
@@ -201,15 +381,18 @@ func (i *Input) TryReadWhile(f func(r rune, last bool) (bool, error)) (Slice, er
 		}
 		test, err := f(r, i.isLast())
 		if err != nil {
+			// Point at all that was read, up to and including r
+			_, size := utf8.DecodeRuneInString(i.str[i.idx-i.base:])
+			errIdx := i.idx + size
 			i.Seek(startIdx)
-			return EmptySlice(), i.ErrorHere(err)
+			return EmptySlice(), i.ErrorAt(startIdx, errIdx, err)
 		}
 		if test {
 			i.Shift(r)
 		} else {
 			break
 		}
-		if i.Rest() == "" {
+		if i.Consumed() {
 			break
 		}
 	}
@@ -224,8 +407,19 @@ func (i *Input) TrimSliceSuffix(slc Slice, suffix string) Slice {
 }
 
 func (i *Input) isLast() bool {
-	_, size := utf8.DecodeRuneInString(i.Rest())
-	return len(i.str)-size == i.idx
+	i.ensure(i.idx + utf8.UTFMax + 1)
+	_, size := utf8.DecodeRuneInString(i.str[i.idx-i.base:])
+	return i.end()-size == i.idx
+}
+
+// end returns the offset just past the text held in str
+func (i *Input) end() int {
+	return i.base + len(i.str)
+}
+
+// text returns the text between offsets from and to, which must be held in str
+func (i *Input) text(from, to int) string {
+	return i.str[from-i.base : to-i.base]
 }
 
 func makeLineIdx(s string) []int {
@@ -243,37 +437,67 @@ func makeLineIdx(s string) []int {
 	return lines
 }
 
-func (i *Input) col() int {
-	var leftIdx int
-	if i.lineNo > 1 {
-		leftIdx = i.lineIdx[i.lineNo-2]
+// lineStart returns the offset of the start of line, which must not have been
+// released
+func (i *Input) lineStart(line int) int {
+	if line == i.firstLine {
+		return i.base
 	}
-	return (i.idx - leftIdx) + 1
+	return i.lineIdx[line-i.firstLine-1]
 }
 
-func (i *Input) findLineAndCol(idx int) (line, col int) {
-	line, col = -1, -1
-	for lineNo, endIdx := range i.lineIdx {
-		if idx < endIdx || idx == len(i.str) {
-			line = lineNo + 1
-			break
-		}
-	}
-	if line == -1 {
-		panic(fmt.Sprintf("unable to find line for idx %d", idx))
+// findLine returns the line containing idx. The end of the input belongs to
+// the last line.
+func (i *Input) findLine(idx int) int {
+	// The first line ending after idx
+	n := sort.Search(len(i.lineIdx), func(n int) bool {
+		return idx < i.lineIdx[n]
+	})
+	if n == len(i.lineIdx) {
+		n--
 	}
+	return i.firstLine + n
+}
 
-	var leftIdx int
-	if line > 1 {
-		leftIdx = i.lineIdx[line-2]
+type colCache struct {
+	line, idx           int
+	runeCol, displayCol int
+}
+
+// posOnLine returns the position of idx, which is on line. Positions within
+// the BOM are given the first column.
+func (i *Input) posOnLine(idx, line int) Pos {
+	start := i.lineStart(line)
+	if line == 1 {
+		start = i.textStart
+	}
+	to := idx
+	if to < start {
+		to = start
+	}
+	c := &i.cols
+	if c.line != line || c.idx > to {
+		*c = colCache{line: line, idx: start, runeCol: 1, displayCol: 1}
 	}
-	col = (idx - leftIdx) + 1
-	return line, col
+	c.runeCol, c.displayCol = column.Advance(i.text(c.idx, to), c.runeCol, c.displayCol, i.tabWidth)
+	c.idx = to
+	return Pos{Idx: idx, Row: line, Col: to - start + 1, RuneCol: c.runeCol, DisplayCol: c.displayCol}
 }
 
 func (i *Input) SplitNewline(slc Slice) []Slice {
+	slices, err := i.TrySplitNewline(slc)
+	if err != nil {
+		panic(err)
+	}
+	return slices
+}
+
+func (i *Input) TrySplitNewline(slc Slice) ([]Slice, error) {
 	if i.name != slc.Name {
-		panic(fmt.Sprintf("unable to split splice from different file. got %q want %q", slc.Name, i.name))
+		return nil, fmt.Errorf("unable to split splice from different file. got %q want %q", slc.Name, i.name)
+	}
+	if slc.Start.Idx < i.base || slc.End.Idx > i.end() || slc.Start.Idx > slc.End.Idx || i.text(slc.Start.Idx, slc.End.Idx) != slc.S {
+		return nil, fmt.Errorf("unable to split slice %s not taken from this Input", slc)
 	}
 	var slices []Slice
 	split := strings.Split(slc.S, "\n")
@@ -283,9 +507,10 @@ func (i *Input) SplitNewline(slc Slice) []Slice {
 			startIdx += len(s) + 1
 			continue
 		}
-		endIdx := startIdx + len(s)
+		// Leave the "\r" of a "\r\n" line ending off the line
+		endIdx := startIdx + len(strings.TrimSuffix(s, "\r"))
 		slices = append(slices, i.SliceAt(startIdx, endIdx))
-		startIdx = endIdx + 1
+		startIdx += len(s) + 1
 	}
-	return slices
+	return slices, nil
 }
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"unicode"
 
 	"github.com/BestFriendChris/go-ic/ic"
@@ -119,9 +120,11 @@ func TestInput_TryReadWhile(t *testing.T) {
 			################################################################################
 			# err
 			################################################################################
-			line 1: (1 + (2 * 3)next
-			        ▲
-			        └── unbalanced parens
+			unbalanced parens
+			  ┌─ test:1:1
+			  │
+			1 │ (1 + (2 * 3)next
+			  │ ^^^^^^^^^^^^^^^^
 			################################################################################
 			# in.rest
 			################################################################################
@@ -239,6 +242,115 @@ this is line 3`[1:]
 	})
 }
 
+func TestInput_Columns(t *testing.T) {
+	printCols := func(c *ic.IC, in *Input, s string, subs ...string) {
+		for _, sub := range subs {
+			p := in.PosAt(strings.Index(s, sub))
+			c.Printf("%-6q line=%d col=%d runeCol=%d displayCol=%d\n", sub, p.Row, p.Col, p.RuneCol, p.DisplayCol)
+		}
+	}
+	t.Run("runes and tabs", func(t *testing.T) {
+		s := "◊x\tdone\n\t◊.é\tend"
+		in := NewInput("test", s)
+
+		c := ic.New(t)
+		c.PrintSection("default tab width")
+		printCols(&c, in, s, "x", "done", "é", "end")
+		c.PrintSection("tab width 4")
+		in.SetTabWidth(4)
+		printCols(&c, in, s, "x", "done", "é", "end")
+		c.Expect(`
+			################################################################################
+			# default tab width
+			################################################################################
+			"x"    line=1 col=4 runeCol=2 displayCol=2
+			"done" line=1 col=6 runeCol=4 displayCol=9
+			"é"    line=2 col=6 runeCol=4 displayCol=11
+			"end"  line=2 col=9 runeCol=6 displayCol=17
+			################################################################################
+			# tab width 4
+			################################################################################
+			"x"    line=1 col=4 runeCol=2 displayCol=2
+			"done" line=1 col=6 runeCol=4 displayCol=5
+			"é"    line=2 col=6 runeCol=4 displayCol=7
+			"end"  line=2 col=9 runeCol=6 displayCol=9
+			`)
+	})
+	t.Run("CRLF", func(t *testing.T) {
+		s := "one\r\ntwo\r\n"
+		in := NewInput("test", s)
+
+		c := ic.New(t)
+		printCols(&c, in, s, "\r", "\n", "two")
+		c.PVWN("end", in.PosAt(len(s)))
+		c.PVWN("lines", in.SplitNewline(in.RestSlice()))
+		c.Expect(`
+			"\r"   line=1 col=4 runeCol=4 displayCol=4
+			"\n"   line=1 col=5 runeCol=4 displayCol=4
+			"two"  line=2 col=1 runeCol=1 displayCol=1
+			end: input.Pos{Idx:10, Row:3, Col:1, RuneCol:1, DisplayCol:1}
+			lines: []input.Slice{input.Slice{Name:"test", S:"one", Start:input.Pos{Idx:0, Row:1, Col:1, RuneCol:1, DisplayCol:1}, End:input.Pos{Idx:3, Row:1, Col:4, RuneCol:4, DisplayCol:4}}, input.Slice{Name:"test", S:"two", Start:input.Pos{Idx:5, Row:2, Col:1, RuneCol:1, DisplayCol:1}, End:input.Pos{Idx:8, Row:2, Col:4, RuneCol:4, DisplayCol:4}}}
+			`)
+	})
+	t.Run("BOM", func(t *testing.T) {
+		s := "\uFEFFone\ntwo"
+		inputs := map[string]*Input{
+			"from a string": NewInput("test", s),
+			"from a reader": NewReaderInput("test", iotest.OneByteReader(strings.NewReader(s))),
+		}
+
+		c := ic.New(t)
+		for _, name := range []string{"from a string", "from a reader"} {
+			in := inputs[name]
+			c.PrintSection(name)
+			c.PVWN("Pos", in.Pos())
+			c.PVWN("Rest", in.Rest())
+			printCols(&c, in, s, "two")
+		}
+		c.Expect(`
+			################################################################################
+			# from a string
+			################################################################################
+			Pos: input.Pos{Idx:3, Row:1, Col:1, RuneCol:1, DisplayCol:1}
+			Rest: "one\ntwo"
+			"two"  line=2 col=1 runeCol=1 displayCol=1
+			################################################################################
+			# from a reader
+			################################################################################
+			Pos: input.Pos{Idx:3, Row:1, Col:1, RuneCol:1, DisplayCol:1}
+			Rest: "one\ntwo"
+			"two"  line=2 col=1 runeCol=1 displayCol=1
+			`)
+	})
+}
+
+func TestInput_Seek(t *testing.T) {
+	s := "foo\nbar\n"
+	in := NewInput("test", s)
+	c := ic.New(t)
+
+	c.PrintSection("seek to start of line 2")
+	in.Seek(strings.Index(s, "bar"))
+	c.Println(in.Pos())
+
+	c.PrintSection("seek to end")
+	in.Seek(len(s))
+	c.Println(in.Pos())
+	c.Println(in.PosAt(len(s)))
+
+	c.Expect(`
+		################################################################################
+		# seek to start of line 2
+		################################################################################
+		Pos[line=2;col=1]
+		################################################################################
+		# seek to end
+		################################################################################
+		Pos[line=3;col=1]
+		Pos[line=3;col=1]
+		`)
+}
+
 func TestInput_SliceAt(t *testing.T) {
 	t.Run("at newline break", func(t *testing.T) {
 		i := NewInput("test", "\nfoo")
@@ -343,3 +455,134 @@ bar
 			`)
 	})
 }
+
+func TestInput_TryVariants(t *testing.T) {
+	in := NewInput("test", "foo\nbar")
+	in.Seek(1)
+
+	c := ic.New(t)
+	c.Printf("TrySeek(-1): %v\n", in.TrySeek(-1))
+	c.Printf("TrySeek(8): %v\n", in.TrySeek(8))
+	c.Printf("TrySeekOffset(1): %v\n", in.TrySeekOffset(1))
+	c.Printf("TryShift('x'): %v\n", in.TryShift('x'))
+	c.Printf("TryUnshift('o'): %v\n", in.TryUnshift('o'))
+	c.Printf("TryUnshiftString(\"no\"): %v\n", in.TryUnshiftString("no"))
+	_, err := in.TrySliceAt(2, 1)
+	c.Printf("TrySliceAt(2, 1): %v\n", err)
+	_, err = in.TrySplitNewline(NewInput("other", "foo").RestSlice())
+	c.Printf("TrySplitNewline(other): %v\n", err)
+	_, err = TryNewSlice("test", "", Pos{Idx: 2}, Pos{Idx: 1})
+	c.Printf("TryNewSlice: %v\n", err)
+	c.Printf("Pos: %v\n", in.Pos())
+	c.Expect(`
+		TrySeek(-1): unable to seek to negative idx
+		TrySeek(8): unable to seek past end of Input: 8 vs 7
+		TrySeekOffset(1): <nil>
+		TryShift('x'): unable to skip 'x' (found 'o')
+		TryUnshift('o'): <nil>
+		TryUnshiftString("no"): unable to unshift "no": (found "f")
+		TrySliceAt(2, 1): unable to slice [2, 1) from Input holding [0, 7)
+		TrySplitNewline(other): unable to split splice from different file. got "other" want "test"
+		TryNewSlice: invalid state: slice starts at 2 after ending at 1
+		Pos: Pos[line=1;col=2]
+		`)
+}
+
+func TestInput_Release(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	in := NewReaderInput("test", strings.NewReader(strings.Repeat(line, 10_000)))
+
+	var lines, maxHeld int
+	for !in.Consumed() {
+		slc := in.ReadWhile(func(r rune) bool { return r != '\n' })
+		in.Shift('\n')
+		in.Release()
+		lines++
+		if len(in.str) > maxHeld {
+			maxHeld = len(in.str)
+		}
+		if lines == 5_000 {
+			c := ic.New(t)
+			c.Println(slc)
+			c.Println(in.Pos())
+			c.Println(in.ErrorHere(fmt.Errorf("here")))
+			c.Expect(`
+				test:5000 - "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
+				Pos[line=5001;col=1]
+				here
+				     ┌─ test:5001:1
+				     │
+				5000 │ xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
+				5001 │ xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
+				     │ ^
+				5002 │ xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
+				`)
+		}
+	}
+
+	c := ic.New(t)
+	c.PVWN("lines", lines)
+	c.PVWN("held at most 64KiB", maxHeld <= 64*1024)
+	_, err := in.TrySliceAt(0, 1)
+	c.PVWN("released", err != nil)
+	c.Expect(`
+		lines: 10000
+		held at most 64KiB: true
+		released: true
+		`)
+}
+
+func TestInput_RuneCol(t *testing.T) {
+	in := NewInput("test", "héllo ◊x\nwörld")
+	c := ic.New(t)
+	// Out of order, so that some can't carry on from the last
+	for _, idx := range []int{0, 3, 10, 7, 12, 14, 11} {
+		pos := in.PosAt(idx)
+		c.Printf("%2d: line %d, col %2d, rune col %d\n", idx, pos.Row, pos.Col, pos.RuneCol)
+	}
+	c.Expect(`
+		 0: line 1, col  1, rune col 1
+		 3: line 1, col  4, rune col 3
+		10: line 1, col 11, rune col 8
+		 7: line 1, col  8, rune col 7
+		12: line 2, col  1, rune col 1
+		14: line 2, col  3, rune col 3
+		11: line 1, col 12, rune col 9
+		`)
+}
+
+// largeTemplate returns a template of n lines mixing text and code
+func largeTemplate(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "row %d: ◊{ x := %d } value ◊x\n", i, i)
+	}
+	return sb.String()
+}
+
+func BenchmarkInput_SliceAt(b *testing.B) {
+	for _, lines := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("%d lines", lines), func(b *testing.B) {
+			in := NewInput("bench", largeTemplate(lines))
+			step := in.Len() / 997
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				from := (n * step) % (in.Len() - 10)
+				in.SliceAt(from, from+10)
+			}
+		})
+	}
+}
+
+func BenchmarkInput_Seek(b *testing.B) {
+	for _, lines := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("%d lines", lines), func(b *testing.B) {
+			in := NewInput("bench", largeTemplate(lines))
+			step := in.Len() / 997
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				in.Seek((n * step) % in.Len())
+			}
+		})
+	}
+}
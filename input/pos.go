@@ -1,11 +1,43 @@
 package input
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
+// Pos is a position in a template. Idx is its byte offset and Row its line,
+// both counting any BOM. Col is its column in bytes, RuneCol in runes and
+// DisplayCol in the columns the line takes up when displayed, with tabs
+// expanded; see the column package. All three count from 1 at the start of
+// the line's text, so the BOM isn't included.
 type Pos struct {
-	Idx, Row, Col int
+	Idx, Row, Col       int
+	RuneCol, DisplayCol int
 }
 
 func (p Pos) String() string {
 	return fmt.Sprintf("Pos[line=%d;col=%d]", p.Row, p.Col)
 }
+
+type jsonPos struct {
+	Offset     int `json:"offset"`
+	Line       int `json:"line"`
+	Col        int `json:"col"`
+	RuneCol    int `json:"runeCol"`
+	DisplayCol int `json:"displayCol"`
+}
+
+// MarshalJSON encodes p as
+// {"offset":12,"line":2,"col":5,"runeCol":3,"displayCol":10}
+func (p Pos) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPos{p.Idx, p.Row, p.Col, p.RuneCol, p.DisplayCol})
+}
+
+func (p *Pos) UnmarshalJSON(b []byte) error {
+	var jp jsonPos
+	if err := json.Unmarshal(b, &jp); err != nil {
+		return err
+	}
+	*p = Pos{Idx: jp.Offset, Row: jp.Line, Col: jp.Col, RuneCol: jp.RuneCol, DisplayCol: jp.DisplayCol}
+	return nil
+}
@@ -0,0 +1,108 @@
+package input
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+)
+
+const (
+	// readSize is how much an Input reading from an io.Reader reads at a
+	// time, and how much released text it lets build up before dropping it
+	readSize = 32 * 1024
+	// maxLookahead bounds how far ahead regexps can match on an Input
+	// reading from an io.Reader
+	maxLookahead = 4 * 1024
+)
+
+// NewReaderInput returns an Input reading its text from r as it's needed,
+// rather than all at once. Call Release once the text before the current
+// position is finished with so memory use stays flat.
+func NewReaderInput(name string, r io.Reader) *Input {
+	i := &Input{
+		name:       name,
+		lineNo:     1,
+		firstLine:  1,
+		lineIdx:    []int{0},
+		tabWidth:   column.DefaultTabWidth,
+		errContext: errors.DefaultContext,
+		stream:     true,
+		r:          r,
+	}
+	i.skipBOM()
+	return i
+}
+
+// Err returns the error reading from the io.Reader, if any. The input ends
+// where the error occurred.
+func (i *Input) Err() error {
+	return i.err
+}
+
+// Release lets an Input reading from an io.Reader drop the text before the
+// line holding the current position. Slices and positions from before then
+// can no longer be taken, nor can it seek back there. It does nothing for an
+// Input created from a string.
+func (i *Input) Release() {
+	if !i.stream {
+		return
+	}
+	start := i.lineStart(i.lineNo)
+	// Copying what's left is only worth it once enough has built up
+	if start-i.base < readSize {
+		return
+	}
+	i.lineIdx = append([]int(nil), i.lineIdx[i.lineNo-i.firstLine:]...)
+	i.firstLine = i.lineNo
+	i.str = string([]byte(i.str[start-i.base:]))
+	i.base = start
+}
+
+// ensure reads until the text up to offset to is held, or there's nothing
+// left to read
+func (i *Input) ensure(to int) {
+	for i.r != nil && i.end() < to {
+		i.read()
+	}
+}
+
+func (i *Input) ensureAll() {
+	for i.r != nil {
+		i.read()
+	}
+}
+
+// lookahead returns the text from the current position that regexps are
+// matched against
+func (i *Input) lookahead() string {
+	i.ensure(i.idx + maxLookahead)
+	return i.str[i.idx-i.base:]
+}
+
+func (i *Input) read() {
+	buf := make([]byte, readSize)
+	n, err := i.r.Read(buf)
+	if n > 0 {
+		oldEnd := i.end()
+		i.str += string(buf[:n])
+		// The last line now carries on into what was read
+		i.lineIdx = i.lineIdx[:len(i.lineIdx)-1]
+		for off := 0; ; {
+			nl := bytes.IndexByte(buf[off:n], '\n')
+			if nl == -1 {
+				break
+			}
+			off += nl + 1
+			i.lineIdx = append(i.lineIdx, oldEnd+off)
+		}
+		i.lineIdx = append(i.lineIdx, i.end())
+	}
+	if err != nil {
+		if err != io.EOF {
+			i.err = err
+		}
+		i.r = nil
+	}
+}
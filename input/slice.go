@@ -15,10 +15,20 @@ func EmptySlice() Slice {
 }
 
 func NewSlice(name string, s string, start Pos, end Pos) Slice {
+	slc, err := TryNewSlice(name, s, start, end)
+	if err != nil {
+		panic(err)
+	}
+	return slc
+}
+
+// TryNewSlice is NewSlice, returning an error rather than panicking when
+// start is after end
+func TryNewSlice(name string, s string, start Pos, end Pos) (Slice, error) {
 	if start.Idx > end.Idx {
-		panic("invalid state")
+		return EmptySlice(), fmt.Errorf("invalid state: slice starts at %d after ending at %d", start.Idx, end.Idx)
 	}
-	return Slice{Name: name, S: s, Start: start, End: end}
+	return Slice{Name: name, S: s, Start: start, End: end}, nil
 }
 
 func (slc Slice) String() string {
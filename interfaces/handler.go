@@ -8,5 +8,23 @@ type TemplateHandler interface {
 	WriteCodeGlobalBlock(input.Slice)
 	WriteCodeLocalExpression(input.Slice)
 	WriteCodeLocalBlock(input.Slice)
+	// WriteImport receives a single import spec such as `"strings"`,
+	// `str "strings"` or `. "strings"`. Handlers are expected to merge and
+	// deduplicate imports when Done is called.
+	WriteImport(input.Slice)
 	Done() (string, error)
 }
+
+// Segment is the Go code a handler generated for a single slice of the
+// template
+type Segment struct {
+	Src  input.Slice
+	Code string
+}
+
+// SegmentHandler is implemented by handlers able to list the code they
+// generated. The segments must be in the order they appear in the output of
+// Done, with each one's code directly following a //line comment.
+type SegmentHandler interface {
+	Segments() []Segment
+}
@@ -1,11 +1,23 @@
 package interfaces
 
+import (
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
 type Macro interface {
 	Name() string
 	Tokenizer
 	Parser
 }
 
+// TokenTypeRegisterer is implemented by macros that use token types of their
+// own. The macro should register them with ns, then look them up through the
+// TokenTypeSource it's given each time it's used rather than keep them, as the
+// same macro may be used by templates with different registries.
+type TokenTypeRegisterer interface {
+	RegisterTokenTypes(ns token.Namespace)
+}
+
 type Macros struct {
 	mm map[string]Macro
 }
@@ -37,6 +49,19 @@ func (ms *Macros) Get(name string) (m Macro, found bool) {
 	return
 }
 
+// RegisterTokenTypes registers the token types of each macro with r, under a
+// namespace named after the macro
+func (ms *Macros) RegisterTokenTypes(r *token.Registry) {
+	if ms == nil {
+		return
+	}
+	for name, m := range ms.mm {
+		if ttr, ok := m.(TokenTypeRegisterer); ok {
+			ttr.RegisterTokenTypes(r.Namespace(name))
+		}
+	}
+}
+
 func (ms *Macros) Known() []string {
 	var keys []string
 	for name := range ms.mm {
@@ -1,9 +1,43 @@
 package interfaces
 
 import (
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
+// Parser is given the tokens following the macro's own token. It writes
+// whatever the macro produces to h and returns the tokens it didn't consume,
+// which the parser then carries on with.
 type Parser interface {
 	Parse(h TemplateHandler, toks []*token.Token) (rest []*token.Token, err error)
 }
+
+// BodyParser is implemented by the TemplateHandler the default parser passes
+// to Parser.Parse. A macro can hand it tokens it doesn't want to deal with
+// itself, such as a body containing other macros, and they'll be parsed and
+// written in place.
+type BodyParser interface {
+	ParseBody(toks []*token.Token) error
+}
+
+// TokenTypeSource is implemented by the ContentTokenizer passed to
+// Tokenizer.NextTokens, and by the TemplateHandler the default parser passes
+// to Parser.Parse. It gives the token types registered under the name of
+// macro in the template's registry.
+type TokenTypeSource interface {
+	TokenTypes(macro string) token.Namespace
+}
+
+// NodeParser is implemented by macros that build their own node in the
+// template tree, such as block macros. It's given the tokens following the
+// macro's own token and returns whatever it didn't consume.
+type NodeParser interface {
+	ParseNode(tp TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error)
+}
+
+type TreeParser interface {
+	// ParseBlock reads a block opened by a code token ending in `{` up to
+	// its closing `}` code token. Any `} else ... {` code tokens found along
+	// the way start a new branch.
+	ParseBlock(toks []*token.Token) (branches []*ast.Branch, closeTok *token.Token, rest []*token.Token, err error)
+}
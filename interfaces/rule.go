@@ -0,0 +1,93 @@
+package interfaces
+
+import (
+	"sort"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+// Rule checks a template for a single kind of likely mistake, such as an
+// unused loop variable
+type Rule interface {
+	Name() string
+	// Doc is a one line description of what the rule looks for
+	Doc() string
+	Check(p *Pass) []Diagnostic
+}
+
+// Pass is everything a Rule is given about the template it checks
+type Pass struct {
+	Input  *input.Input
+	Marker rune
+	Tokens []*token.Token
+	Nodes  []ast.Node
+}
+
+// Diagnostic is a problem found by a rule. Rules leave Rule empty; it's filled
+// in with the rule's name when the rules are run. Suggestions are possible
+// fixes, which editors can offer as quick fixes.
+type Diagnostic struct {
+	Pos         input.Pos
+	Rule        string
+	Message     string
+	Suggestions []errors.Suggestion
+}
+
+type Rules struct {
+	rm map[string]Rule
+}
+
+func NewRules() *Rules {
+	return &Rules{make(map[string]Rule)}
+}
+
+func (rs *Rules) Add(r Rule) {
+	rs.rm[r.Name()] = r
+}
+
+func (rs *Rules) Merge(other *Rules) *Rules {
+	newRules := NewRules()
+	for name, r := range rs.rm {
+		newRules.rm[name] = r
+	}
+	if other == nil {
+		return newRules
+	}
+	for name, r := range other.rm {
+		newRules.rm[name] = r
+	}
+	return newRules
+}
+
+func (rs *Rules) Get(name string) (r Rule, found bool) {
+	r, found = rs.rm[name]
+	return
+}
+
+// Known returns the names of the rules in alphabetical order
+func (rs *Rules) Known() []string {
+	var keys []string
+	for name := range rs.rm {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check runs every rule over p, returning what they found ordered by position
+func (rs *Rules) Check(p *Pass) []Diagnostic {
+	var diags []Diagnostic
+	for _, name := range rs.Known() {
+		for _, d := range rs.rm[name].Check(p) {
+			d.Rule = name
+			diags = append(diags, d)
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Pos.Idx < diags[j].Pos.Idx
+	})
+	return diags
+}
@@ -14,4 +14,57 @@ type ContentTokenizer interface {
 	ReadTokensUntil(in *input.Input, stopAt string) ([]*token.Token, error)
 	ParseGoCodeFromTo(in *input.Input, tt token.TokenType, open, close rune, keep bool) ([]*token.Token, error)
 	ParseGoToClosingBrace(in *input.Input) ([]*token.Token, error)
+	// DefineMacro adds m to the macros the rest of the template can use,
+	// such as a component the template defines. It returns false if there's
+	// already a macro with m's name.
+	DefineMacro(m Macro) bool
+	// ReadMacroArgs reads the macro called name from the start of in, along
+	// with any arguments and body following it, as in
+	// `name(arg1, key=value) {◊ body ◊}`
+	ReadMacroArgs(in *input.Input, name string) (*MacroArgs, error)
+	TokenTypeSource
+}
+
+// MacroArgs is a macro's arguments and body, as read by ReadMacroArgs
+type MacroArgs struct {
+	Name input.Slice
+	// List holds the arguments in their brackets, and is empty if the macro
+	// was given none
+	List input.Slice
+	Args []Arg
+	// Open and Close are the "{◊" and "◊}" around Body, and are empty if the
+	// macro has no body
+	Open, Close input.Slice
+	Body        []*token.Token
+}
+
+// Arg is a Go expression given as an argument to a macro. Key is empty for
+// an argument given by position rather than as key=value.
+type Arg struct {
+	Key, Value input.Slice
+}
+
+func (ma *MacroArgs) HasBody() bool {
+	return ma.Open.Len() > 0
+}
+
+// Positional returns the arguments given by position
+func (ma *MacroArgs) Positional() []Arg {
+	var args []Arg
+	for _, a := range ma.Args {
+		if a.Key.Len() == 0 {
+			args = append(args, a)
+		}
+	}
+	return args
+}
+
+// Named returns the argument given for key
+func (ma *MacroArgs) Named(key string) (arg Arg, found bool) {
+	for _, a := range ma.Args {
+		if a.Key.Len() > 0 && a.Key.S == key {
+			return a, true
+		}
+	}
+	return Arg{}, false
 }
@@ -0,0 +1,200 @@
+package ast
+
+import (
+	"github.com/BestFriendChris/lozenge_template/input"
+)
+
+// Node is a piece of a parsed template. Start and End give the range of the
+// template it was parsed from.
+type Node interface {
+	Start() input.Pos
+	End() input.Pos
+}
+
+// Text is content written to the output as-is
+type Text struct {
+	Slc input.Slice
+}
+
+func (n *Text) Start() input.Pos { return n.Slc.Start }
+func (n *Text) End() input.Pos   { return n.Slc.End }
+
+// Expr is a Go expression whose value is written to the output
+type Expr struct {
+	Slc input.Slice
+}
+
+func (n *Expr) Start() input.Pos { return n.Slc.Start }
+func (n *Expr) End() input.Pos   { return n.Slc.End }
+
+// Code is a line of Go code run in place
+type Code struct {
+	Slc input.Slice
+}
+
+func (n *Code) Start() input.Pos { return n.Slc.Start }
+func (n *Code) End() input.Pos   { return n.Slc.End }
+
+// GlobalCode is a line of Go code declared outside the template body
+type GlobalCode struct {
+	Slc input.Slice
+}
+
+func (n *GlobalCode) Start() input.Pos { return n.Slc.Start }
+func (n *GlobalCode) End() input.Pos   { return n.Slc.End }
+
+// Import is a single import spec requested by the template
+type Import struct {
+	Slc input.Slice
+}
+
+func (n *Import) Start() input.Pos { return n.Slc.Start }
+func (n *Import) End() input.Pos   { return n.Slc.End }
+
+// Branch is one arm of a block macro: the Go code opening it (e.g. `if x {`
+// or `} else {`) followed by its body
+type Branch struct {
+	Head input.Slice
+	Body []Node
+}
+
+func (n *Branch) Start() input.Pos { return n.Head.Start }
+func (n *Branch) End() input.Pos {
+	if len(n.Body) == 0 {
+		return n.Head.End
+	}
+	return n.Body[len(n.Body)-1].End()
+}
+
+// If is an ◊.if macro. The first branch holds the `if` itself, followed by any
+// `else if` and `else` branches.
+type If struct {
+	Macro    input.Slice
+	Branches []*Branch
+	Close    input.Slice
+}
+
+func (n *If) Start() input.Pos { return n.Macro.Start }
+func (n *If) End() input.Pos   { return n.Close.End }
+
+// For is an ◊.for macro. Vars holds the variables its for clause declares.
+// Helper names the loop's helper, if it has one, in which case Range is the
+// expression it ranges over. Else is the branch written when Body never is.
+type For struct {
+	Macro  input.Slice
+	Vars   []string
+	Helper string
+	Head   input.Slice
+	Range  input.Slice
+	Body   []Node
+	Else   *Branch
+	Close  input.Slice
+}
+
+func (n *For) Start() input.Pos { return n.Macro.Start }
+func (n *For) End() input.Pos   { return n.Close.End }
+
+// Component is an ◊.define macro declaring a component called Name. Params
+// holds its Go parameter list, without the brackets.
+type Component struct {
+	Macro  input.Slice
+	Name   string
+	Params string
+	Head   input.Slice
+	Body   []Node
+	Close  input.Slice
+}
+
+func (n *Component) Start() input.Pos { return n.Macro.Start }
+func (n *Component) End() input.Pos   { return n.Close.End }
+
+// Call is a call of a component, with Args holding its Go arguments in their
+// brackets. Body is passed to the component's slot. Close is empty when the
+// component is called without a body.
+type Call struct {
+	Name  input.Slice
+	Args  input.Slice
+	Body  []Node
+	Close input.Slice
+}
+
+func (n *Call) Start() input.Pos { return n.Name.Start }
+func (n *Call) End() input.Pos {
+	if n.Close.Len() == 0 {
+		return n.Args.End
+	}
+	return n.Close.End
+}
+
+// Slot is an ◊.slot macro, where a component writes the body it was called
+// with
+type Slot struct {
+	Macro input.Slice
+}
+
+func (n *Slot) Start() input.Pos { return n.Macro.Start }
+func (n *Slot) End() input.Pos   { return n.Macro.End }
+
+// Sep is an ◊.sep macro, whose Body is written between the times round the
+// ◊.for it's in
+type Sep struct {
+	Macro input.Slice
+	Body  []Node
+	Close input.Slice
+}
+
+func (n *Sep) Start() input.Pos { return n.Macro.Start }
+func (n *Sep) End() input.Pos   { return n.Close.End }
+
+// Macro is any other macro along with the nodes it produced
+type Macro struct {
+	Name input.Slice
+	Body []Node
+}
+
+func (n *Macro) Start() input.Pos { return n.Name.Start }
+func (n *Macro) End() input.Pos {
+	if len(n.Body) == 0 {
+		return n.Name.End
+	}
+	return n.Body[len(n.Body)-1].End()
+}
+
+// Children returns the nodes directly nested inside n
+func Children(n Node) []Node {
+	switch n := n.(type) {
+	case *Branch:
+		return n.Body
+	case *If:
+		children := make([]Node, len(n.Branches))
+		for i, b := range n.Branches {
+			children[i] = b
+		}
+		return children
+	case *For:
+		if n.Else == nil {
+			return n.Body
+		}
+		return append(n.Body[:len(n.Body):len(n.Body)], n.Else)
+	case *Component:
+		return n.Body
+	case *Call:
+		return n.Body
+	case *Sep:
+		return n.Body
+	case *Macro:
+		return n.Body
+	default:
+		return nil
+	}
+}
+
+// Inspect traverses nodes depth-first, calling f for each node. If f returns
+// false the children of that node are skipped.
+func Inspect(nodes []Node, f func(Node) bool) {
+	for _, n := range nodes {
+		if f(n) {
+			Inspect(Children(n), f)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+)
+
+type jsonNode struct {
+	Kind     string     `json:"kind"`
+	Text     string     `json:"text,omitempty"`
+	Vars     []string   `json:"vars,omitempty"`
+	Start    input.Pos  `json:"start"`
+	End      input.Pos  `json:"end"`
+	Children []jsonNode `json:"children,omitempty"`
+}
+
+// FprintJSON writes nodes to w as an indented JSON array, nesting each node's
+// children inside it
+func FprintJSON(w io.Writer, nodes []Node) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toJSON(nodes))
+}
+
+func toJSON(nodes []Node) []jsonNode {
+	out := make([]jsonNode, len(nodes))
+	for i, n := range nodes {
+		jn := jsonNode{
+			Start:    n.Start(),
+			End:      n.End(),
+			Children: toJSON(Children(n)),
+		}
+		switch n := n.(type) {
+		case *Text:
+			jn.Kind, jn.Text = "Text", n.Slc.S
+		case *Expr:
+			jn.Kind, jn.Text = "Expr", n.Slc.S
+		case *Code:
+			jn.Kind, jn.Text = "Code", n.Slc.S
+		case *GlobalCode:
+			jn.Kind, jn.Text = "GlobalCode", n.Slc.S
+		case *Import:
+			jn.Kind, jn.Text = "Import", n.Slc.S
+		case *Branch:
+			jn.Kind, jn.Text = "Branch", n.Head.S
+		case *If:
+			jn.Kind = "If"
+		case *For:
+			jn.Kind, jn.Text, jn.Vars = "For", n.Head.S, n.Vars
+		case *Component:
+			jn.Kind, jn.Text = "Component", n.Head.S
+		case *Call:
+			jn.Kind, jn.Text = "Call", n.Name.S+n.Args.S
+		case *Slot:
+			jn.Kind = "Slot"
+		case *Sep:
+			jn.Kind = "Sep"
+		case *Macro:
+			jn.Kind, jn.Text = "Macro", n.Name.S
+		default:
+			jn.Kind = strings.TrimPrefix(fmt.Sprintf("%T", n), "*")
+		}
+		out[i] = jn
+	}
+	return out
+}
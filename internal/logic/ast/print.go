@@ -0,0 +1,56 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes an indented outline of nodes to w
+func Fprint(w io.Writer, nodes []Node) error {
+	return fprint(w, nodes, 0)
+}
+
+func fprint(w io.Writer, nodes []Node, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	for _, n := range nodes {
+		var err error
+		switch n := n.(type) {
+		case *Text:
+			_, err = fmt.Fprintf(w, "%sText %q\n", indent, n.Slc.S)
+		case *Expr:
+			_, err = fmt.Fprintf(w, "%sExpr %q\n", indent, n.Slc.S)
+		case *Code:
+			_, err = fmt.Fprintf(w, "%sCode %q\n", indent, n.Slc.S)
+		case *GlobalCode:
+			_, err = fmt.Fprintf(w, "%sGlobalCode %q\n", indent, n.Slc.S)
+		case *Import:
+			_, err = fmt.Fprintf(w, "%sImport %s\n", indent, n.Slc.S)
+		case *Branch:
+			_, err = fmt.Fprintf(w, "%sBranch %q\n", indent, n.Head.S)
+		case *If:
+			_, err = fmt.Fprintf(w, "%sIf\n", indent)
+		case *For:
+			_, err = fmt.Fprintf(w, "%sFor %q\n", indent, n.Head.S)
+		case *Component:
+			_, err = fmt.Fprintf(w, "%sComponent %q\n", indent, n.Head.S)
+		case *Call:
+			_, err = fmt.Fprintf(w, "%sCall %q\n", indent, n.Name.S+n.Args.S)
+		case *Slot:
+			_, err = fmt.Fprintf(w, "%sSlot\n", indent)
+		case *Sep:
+			_, err = fmt.Fprintf(w, "%sSep\n", indent)
+		case *Macro:
+			_, err = fmt.Fprintf(w, "%sMacro %s\n", indent, n.Name.S)
+		default:
+			_, err = fmt.Fprintf(w, "%s%T\n", indent, n)
+		}
+		if err != nil {
+			return err
+		}
+		if err = fprint(w, Children(n), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Package column works out where a byte offset falls within a line of text,
+// counted both in runes and in the columns the line takes up when displayed.
+// The input package and the error messages pointing into a template share it
+// so that they always agree.
+package column
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultTabWidth is the tab width used unless another is asked for, matching
+// the tab stops of most terminals
+const DefaultTabWidth = 8
+
+// BOM is the byte order mark some editors write at the start of a UTF-8 file.
+// It's not part of the first line.
+const BOM = "\uFEFF"
+
+// Of returns the rune and display columns, both counting from 1, of the byte
+// at offset n of line. Tabs move the display column on to the next multiple
+// of tabWidth, and every other rune takes up one column. A "\r" counts for
+// neither, as it's usually part of a "\r\n" line ending. Each invalid byte
+// counts as a rune of its own, as that's how it's printed.
+func Of(line string, n, tabWidth int) (runeCol, displayCol int) {
+	return Advance(line[:n], 1, 1, tabWidth)
+}
+
+// Advance returns the columns reached after s, starting from runeCol and
+// displayCol
+func Advance(s string, runeCol, displayCol, tabWidth int) (int, int) {
+	if tabWidth < 1 {
+		tabWidth = DefaultTabWidth
+	}
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch r {
+		case '\r':
+			continue
+		case '\t':
+			displayCol += tabWidth - (displayCol-1)%tabWidth
+		default:
+			displayCol++
+		}
+		runeCol++
+	}
+	return runeCol, displayCol
+}
+
+// Expand returns line as it's displayed, with any tabs replaced by spaces
+// and any "\r" removed. Use Of for the column each byte ends up in.
+func Expand(line string, tabWidth int) string {
+	if !strings.ContainsAny(line, "\t\r") {
+		return line
+	}
+	var sb strings.Builder
+	displayCol := 1
+	for len(line) > 0 {
+		r, size := utf8.DecodeRuneInString(line)
+		s := line[:size]
+		line = line[size:]
+		switch r {
+		case '\r':
+			continue
+		case '\t':
+			_, next := Advance(s, 1, displayCol, tabWidth)
+			sb.WriteString(strings.Repeat(" ", next-displayCol))
+			displayCol = next
+		default:
+			sb.WriteString(s)
+			displayCol++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,72 @@
+package column
+
+import (
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/BestFriendChris/go-ic/ic"
+)
+
+func TestOf(t *testing.T) {
+	line := "a\t◊é\tb\r"
+
+	c := ic.New(t)
+	for _, tabWidth := range []int{8, 4, 0} {
+		c.PrintSection(fmt.Sprintf("tab width %d", tabWidth))
+		for n := 0; n <= len(line); n++ {
+			if n < len(line) && !utf8.RuneStart(line[n]) {
+				continue
+			}
+			runeCol, displayCol := Of(line, n, tabWidth)
+			c.Printf("%2d %q: runeCol=%d displayCol=%d\n", n, line[:n], runeCol, displayCol)
+		}
+	}
+	c.Expect(`
+		################################################################################
+		# tab width 8
+		################################################################################
+		 0 "": runeCol=1 displayCol=1
+		 1 "a": runeCol=2 displayCol=2
+		 2 "a\t": runeCol=3 displayCol=9
+		 5 "a\t◊": runeCol=4 displayCol=10
+		 7 "a\t◊é": runeCol=5 displayCol=11
+		 8 "a\t◊é\t": runeCol=6 displayCol=17
+		 9 "a\t◊é\tb": runeCol=7 displayCol=18
+		10 "a\t◊é\tb\r": runeCol=7 displayCol=18
+		################################################################################
+		# tab width 4
+		################################################################################
+		 0 "": runeCol=1 displayCol=1
+		 1 "a": runeCol=2 displayCol=2
+		 2 "a\t": runeCol=3 displayCol=5
+		 5 "a\t◊": runeCol=4 displayCol=6
+		 7 "a\t◊é": runeCol=5 displayCol=7
+		 8 "a\t◊é\t": runeCol=6 displayCol=9
+		 9 "a\t◊é\tb": runeCol=7 displayCol=10
+		10 "a\t◊é\tb\r": runeCol=7 displayCol=10
+		################################################################################
+		# tab width 0
+		################################################################################
+		 0 "": runeCol=1 displayCol=1
+		 1 "a": runeCol=2 displayCol=2
+		 2 "a\t": runeCol=3 displayCol=9
+		 5 "a\t◊": runeCol=4 displayCol=10
+		 7 "a\t◊é": runeCol=5 displayCol=11
+		 8 "a\t◊é\t": runeCol=6 displayCol=17
+		 9 "a\t◊é\tb": runeCol=7 displayCol=18
+		10 "a\t◊é\tb\r": runeCol=7 displayCol=18
+		`)
+}
+
+func TestExpand(t *testing.T) {
+	c := ic.New(t)
+	c.PVWN("no tabs", Expand("◊x", 8))
+	c.PVWN("tabs", Expand("\ta\tbc\t◊\td", 4))
+	c.PVWN("CRLF", Expand("a\tb\r", 2))
+	c.Expect(`
+		no tabs: "◊x"
+		tabs: "    a   bc  ◊   d"
+		CRLF: "a b"
+		`)
+}
@@ -0,0 +1,225 @@
+// Package coverage maps a Go coverage profile of generated code back onto the
+// template it was generated from.
+package coverage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/cover"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/sourcemap"
+)
+
+type Status int
+
+const (
+	// NotTracked code has no statements the coverage profile counts, e.g.
+	// plain text between blocks or a global declaration
+	NotTracked Status = iota
+	Covered
+	Partial
+	Uncovered
+)
+
+func (s Status) String() string {
+	switch s {
+	case Covered:
+		return "covered"
+	case Partial:
+		return "partial"
+	case Uncovered:
+		return "uncovered"
+	default:
+		return "not tracked"
+	}
+}
+
+// merge combines the status of two pieces of code sharing a line or block
+func (s Status) merge(other Status) Status {
+	switch {
+	case s == NotTracked:
+		return other
+	case other == NotTracked || s == other:
+		return s
+	default:
+		return Partial
+	}
+}
+
+// Line is a single line of the template
+type Line struct {
+	Row    int
+	Text   string
+	Status Status
+}
+
+// NeverRan is an ◊.if branch or ◊.for body whose code never ran
+type NeverRan struct {
+	Pos     input.Pos
+	Message string
+}
+
+type Report struct {
+	Name     string
+	Lines    []Line
+	NeverRan []NeverRan
+}
+
+// New builds the coverage report for the template whose text is src. nodes is
+// its parse tree and sm the source map of the code generated from it. The
+// profile covering sm.File is picked out of profiles.
+func New(src input.Slice, nodes []ast.Node, sm *sourcemap.SourceMap, profiles []*cover.Profile) (*Report, error) {
+	profile, err := findProfile(profiles, sm.File)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(sm.Mappings))
+	for i, m := range sm.Mappings {
+		statuses[i] = blockStatus(profile.Blocks, m.GoStart)
+	}
+
+	r := &Report{Name: src.Name}
+	for i, text := range strings.Split(src.S, "\n") {
+		r.Lines = append(r.Lines, Line{Row: i + 1, Text: text})
+	}
+	for i, m := range sm.Mappings {
+		first, last := m.Src.Start.Row, m.Src.End.Row
+		// A slice ending in a newline ends at the start of the next line
+		if last > first && m.Src.End.Col == 1 {
+			last--
+		}
+		for row := first; row <= last && row <= len(r.Lines); row++ {
+			r.Lines[row-1].Status = r.Lines[row-1].Status.merge(statuses[i])
+		}
+	}
+
+	// status returns the combined status of the code generated from the
+	// template between start and end
+	status := func(start, end input.Pos) Status {
+		s := NotTracked
+		for i, m := range sm.Mappings {
+			if start.Idx <= m.Src.Start.Idx && m.Src.Start.Idx < end.Idx {
+				s = s.merge(statuses[i])
+			}
+		}
+		return s
+	}
+	ast.Inspect(nodes, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.If:
+			for _, b := range n.Branches {
+				if len(b.Body) > 0 && status(b.Body[0].Start(), b.End()) == Uncovered {
+					r.NeverRan = append(r.NeverRan, NeverRan{
+						Pos:     b.Head.Start,
+						Message: fmt.Sprintf("if branch %q never ran", strings.TrimSpace(b.Head.S)),
+					})
+				}
+			}
+		case *ast.For:
+			if len(n.Body) > 0 && status(n.Body[0].Start(), n.Body[len(n.Body)-1].End()) == Uncovered {
+				r.NeverRan = append(r.NeverRan, NeverRan{
+					Pos:     n.Head.Start,
+					Message: fmt.Sprintf("for body %q never ran", strings.TrimSpace(n.Head.S)),
+				})
+			}
+			if n.Else != nil && len(n.Else.Body) > 0 && status(n.Else.Body[0].Start(), n.Else.End()) == Uncovered {
+				r.NeverRan = append(r.NeverRan, NeverRan{
+					Pos:     n.Else.Head.Start,
+					Message: fmt.Sprintf("for else branch of %q never ran", strings.TrimSpace(n.Head.S)),
+				})
+			}
+		}
+		return true
+	})
+	return r, nil
+}
+
+// Summary returns how many of the template's lines were covered, out of those
+// the coverage profile tracks. Partly covered lines count as covered.
+func (r *Report) Summary() (covered, tracked int) {
+	for _, l := range r.Lines {
+		switch l.Status {
+		case Covered, Partial:
+			covered++
+			tracked++
+		case Uncovered:
+			tracked++
+		}
+	}
+	return covered, tracked
+}
+
+// findProfile returns the profile for the generated file goFile. Profiles name
+// files by import path, so goFile is matched either as given or by the import
+// path it has in the module holding it.
+func findProfile(profiles []*cover.Profile, goFile string) (*cover.Profile, error) {
+	if goFile == "" {
+		return nil, fmt.Errorf("coverage: source map doesn't name the generated file")
+	}
+	names := map[string]bool{filepath.ToSlash(goFile): true}
+	if name, err := importPath(goFile); err == nil {
+		names[name] = true
+	}
+	var found []*cover.Profile
+	for _, p := range profiles {
+		if names[p.FileName] {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("coverage: no profile for %s", goFile)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("coverage: %d profiles match %s", len(found), goFile)
+	}
+}
+
+// importPath returns the name goFile has in a coverage profile: its path
+// within the module holding it, after the module path from that go.mod
+func importPath(goFile string) (string, error) {
+	abs, err := filepath.Abs(goFile)
+	if err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			mod := modfile.ModulePath(data)
+			if mod == "" {
+				return "", fmt.Errorf("coverage: no module path in %s", filepath.Join(dir, "go.mod"))
+			}
+			rel, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return "", err
+			}
+			return path.Join(mod, filepath.ToSlash(rel)), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return "", fmt.Errorf("coverage: no go.mod above %s", goFile)
+		}
+	}
+}
+
+// blockStatus returns whether the profile block containing pos ran
+func blockStatus(blocks []cover.ProfileBlock, pos input.Pos) Status {
+	for _, b := range blocks {
+		afterStart := pos.Row > b.StartLine || pos.Row == b.StartLine && pos.Col >= b.StartCol
+		beforeEnd := pos.Row < b.EndLine || pos.Row == b.EndLine && pos.Col < b.EndCol
+		if afterStart && beforeEnd {
+			if b.Count > 0 {
+				return Covered
+			}
+			return Uncovered
+		}
+	}
+	return NotTracked
+}
@@ -0,0 +1,211 @@
+package coverage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template"
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/sourcemap"
+)
+
+const testTemplate = `◊{ n := 0 }
+◊.if n > 0 {◊
+  positive
+◊} else {◊
+  zero
+◊}
+◊.for i := 0; i < n; i++ {◊
+  item ◊i
+◊}
+done`
+
+func TestNew(t *testing.T) {
+	// Pretend the tests only ran with n == 0, so the if branch and the for
+	// body on lines 3 and 8 never ran
+	r := newReport(t, func(m sourcemap.Mapping) int {
+		if m.Src.Start.Row == 3 || m.Src.Start.Row == 8 {
+			return 0
+		}
+		return 1
+	})
+
+	c := ic.New(t)
+	c.PrintSection("text")
+	var buf bytes.Buffer
+	_ = r.WriteText(&buf)
+	c.Print(buf.String())
+	c.PrintSection("lines")
+	for _, l := range r.Lines {
+		c.Printf("%2d %-11s %s\n", l.Row, l.Status, l.Text)
+	}
+	c.Expect(`
+		################################################################################
+		# text
+		################################################################################
+		test.◊: 8 of 10 lines covered (80.0%)
+		test.◊:2: if branch "if n > 0 {" never ran
+		test.◊:7: for body "for i := 0; i < n; i++ {" never ran
+		test.◊:3: uncovered
+		test.◊:8: uncovered
+		################################################################################
+		# lines
+		################################################################################
+		 1 covered     ◊{ n := 0 }
+		 2 covered     ◊.if n > 0 {◊
+		 3 uncovered     positive
+		 4 covered     ◊} else {◊
+		 5 covered       zero
+		 6 covered     ◊}
+		 7 covered     ◊.for i := 0; i < n; i++ {◊
+		 8 uncovered     item ◊i
+		 9 covered     ◊}
+		10 covered     done
+		`)
+}
+
+func TestReport_WriteHTML(t *testing.T) {
+	r := newReport(t, func(m sourcemap.Mapping) int {
+		if m.Src.Start.Row == 3 {
+			return 0
+		}
+		return 1
+	})
+
+	var buf bytes.Buffer
+	err := r.WriteHTML(&buf)
+
+	c := ic.New(t)
+	c.Print(buf.String())
+	c.Println(err)
+	c.Expect(`
+		<!DOCTYPE html>
+		<html>
+		<head>
+		<meta charset="utf-8">
+		<title>test.◊ coverage</title>
+		<style>
+		body { font-family: sans-serif; }
+		pre { line-height: 1.4; }
+		.row { color: #999; display: inline-block; text-align: right; width: 4em; padding-right: 1em; }
+		.covered { background: #cfc; }
+		.partial { background: #ffc; }
+		.uncovered { background: #fcc; }
+		</style>
+		</head>
+		<body>
+		<h1>test.◊</h1>
+		<p>9 of 10 lines covered</p>
+		<ul>
+		<li><a href="#L2">line 2</a>: if branch &#34;if n &gt; 0 {&#34; never ran</li>
+		</ul>
+		<pre>
+		<span id="L1" class="covered"><span class="row">1</span>◊{ n := 0 }</span>
+		<span id="L2" class="covered"><span class="row">2</span>◊.if n &gt; 0 {◊</span>
+		<span id="L3" class="uncovered"><span class="row">3</span>  positive</span>
+		<span id="L4" class="covered"><span class="row">4</span>◊} else {◊</span>
+		<span id="L5" class="covered"><span class="row">5</span>  zero</span>
+		<span id="L6" class="covered"><span class="row">6</span>◊}</span>
+		<span id="L7" class="covered"><span class="row">7</span>◊.for i := 0; i &lt; n; i&#43;&#43; {◊</span>
+		<span id="L8" class="covered"><span class="row">8</span>  item ◊i</span>
+		<span id="L9" class="covered"><span class="row">9</span>◊}</span>
+		<span id="L10" class="covered"><span class="row">10</span>done</span>
+		</pre>
+		</body>
+		</html>
+		<nil>
+		`)
+}
+
+func TestNew_errorCases(t *testing.T) {
+	t.Run("no profile for the file", func(t *testing.T) {
+		in := input.NewInput("test.◊", "hi")
+		sm := &sourcemap.SourceMap{File: "other/test.go"}
+		profiles := []*cover.Profile{{FileName: "example.com/page/page.go"}}
+		_, err := New(in.RestSlice(), nil, sm, profiles)
+
+		c := ic.New(t)
+		c.Println(err)
+		c.Expect(`
+			coverage: no profile for other/test.go
+			`)
+	})
+	t.Run("only the base name matches", func(t *testing.T) {
+		in := input.NewInput("test.◊", "hi")
+		dir := moduleDir(t, "example.com/page")
+		sm := &sourcemap.SourceMap{File: filepath.Join(dir, "test.go")}
+		profiles := []*cover.Profile{{FileName: "example.com/other/test.go"}}
+		_, err := New(in.RestSlice(), nil, sm, profiles)
+
+		c := ic.New(t)
+		c.Println(strings.Replace(err.Error(), dir, "DIR", 1))
+		c.Expect(`
+			coverage: no profile for DIR/test.go
+			`)
+	})
+	t.Run("more than one profile matches", func(t *testing.T) {
+		in := input.NewInput("test.◊", "hi")
+		dir := moduleDir(t, "example.com/page")
+		sm := &sourcemap.SourceMap{File: filepath.Join(dir, "test.go")}
+		profiles := []*cover.Profile{
+			{FileName: "example.com/page/test.go"},
+			{FileName: filepath.ToSlash(filepath.Join(dir, "test.go"))},
+		}
+		_, err := New(in.RestSlice(), nil, sm, profiles)
+
+		c := ic.New(t)
+		c.Println(strings.Replace(err.Error(), dir, "DIR", 1))
+		c.Expect(`
+			coverage: 2 profiles match DIR/test.go
+			`)
+	})
+}
+
+// moduleDir returns a temporary directory holding a go.mod for module
+func moduleDir(t *testing.T, module string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+module+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newReport generates the code for testTemplate and reports on a profile
+// with a block for each statement, run count times
+func newReport(t *testing.T, count func(m sourcemap.Mapping) int) *Report {
+	t.Helper()
+	lt := lozenge_template.New(nil, lozenge_template.NewParserConfig().WithTrimSpaces())
+	_, sm, err := lt.GenerateWithSourceMap(&main_handler.MainHandler{}, input.NewInput("test.◊", testTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.File = filepath.Join(moduleDir(t, "example.com/page"), "test.go")
+	nodes, err := lt.ParseTree(&main_handler.MainHandler{}, input.NewInput("test.◊", testTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &cover.Profile{FileName: "example.com/page/test.go", Mode: "set"}
+	for _, m := range sm.Mappings {
+		profile.Blocks = append(profile.Blocks, cover.ProfileBlock{
+			StartLine: m.GoStart.Row,
+			StartCol:  m.GoStart.Col,
+			EndLine:   m.GoEnd.Row,
+			EndCol:    m.GoEnd.Col,
+			NumStmt:   1,
+			Count:     count(m),
+		})
+	}
+	r, err := New(input.NewInput("test.◊", testTemplate).RestSlice(), nodes, sm, []*cover.Profile{profile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
@@ -0,0 +1,85 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// WriteText writes a summary of the report to w, followed by each branch that
+// never ran and each line that wasn't covered
+func (r *Report) WriteText(w io.Writer) error {
+	covered, tracked := r.Summary()
+	percent := 100.0
+	if tracked > 0 {
+		percent = 100 * float64(covered) / float64(tracked)
+	}
+	if _, err := fmt.Fprintf(w, "%s: %d of %d lines covered (%.1f%%)\n", r.Name, covered, tracked, percent); err != nil {
+		return err
+	}
+	for _, nr := range r.NeverRan {
+		if _, err := fmt.Fprintf(w, "%s:%d: %s\n", r.Name, nr.Pos.Row, nr.Message); err != nil {
+			return err
+		}
+	}
+	for _, l := range r.Lines {
+		if l.Status != Uncovered && l.Status != Partial {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s:%d: %s\n", r.Name, l.Row, l.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { line-height: 1.4; }
+.row { color: #999; display: inline-block; text-align: right; width: 4em; padding-right: 1em; }
+.covered { background: #cfc; }
+.partial { background: #ffc; }
+.uncovered { background: #fcc; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Covered}} of {{.Tracked}} lines covered</p>
+{{if .NeverRan}}<ul>
+{{range .NeverRan}}<li><a href="#L{{.Pos.Row}}">line {{.Pos.Row}}</a>: {{.Message}}</li>
+{{end}}</ul>
+{{end}}<pre>
+{{range .Lines}}<span id="L{{.Row}}" class="{{.Class}}"><span class="row">{{.Row}}</span>{{.Text}}</span>
+{{end}}</pre>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report to w as a page showing the template with each
+// line coloured by its coverage
+func (r *Report) WriteHTML(w io.Writer) error {
+	type htmlLine struct {
+		Line
+		Class string
+	}
+	covered, tracked := r.Summary()
+	data := struct {
+		Name             string
+		Covered, Tracked int
+		NeverRan         []NeverRan
+		Lines            []htmlLine
+	}{Name: r.Name, Covered: covered, Tracked: tracked, NeverRan: r.NeverRan}
+	for _, l := range r.Lines {
+		var class string
+		if l.Status != NotTracked {
+			class = l.Status.String()
+		}
+		data.Lines = append(data.Lines, htmlLine{l, class})
+	}
+	return htmlTemplate.Execute(w, data)
+}
@@ -1,59 +1,101 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
-	"strings"
-	"unicode/utf8"
+
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
 )
 
+// DefaultContext is how many lines are shown either side of those an error
+// points at, unless another number is asked for
+const DefaultContext = 1
+
+// Source is the template text an error points into. Text holds the template
+// from byte offset Base onwards, which is the start of line FirstLine.
+type Source struct {
+	Name            string
+	Text            string
+	Base, FirstLine int
+	TabWidth        int
+}
+
+// Label marks the template text between byte offsets From and To, which may
+// be the same to mark a single place
+type Label struct {
+	From, To int
+	Msg      string
+}
+
+// Suggestion is a possible fix for an error, replacing the template text
+// between byte offsets From and To with Text. Editors can offer it as a
+// quick fix.
+type Suggestion struct {
+	From, To int
+	Text     string
+}
+
+// TokenizerError is an error in a template, shown along with the part of the
+// template it's in. The first label marks the text in error and any others
+// mark related places. Context lines are shown either side of those labelled.
+// Renderer turns it into text, defaulting to a SnippetRenderer.
 type TokenizerError struct {
-	Msg string
-	Err error
+	Err         error
+	Src         Source
+	Labels      []Label
+	Suggestions []Suggestion
+	Context     int
+	Renderer    Renderer
 }
 
 func NewTokenizerError(input string, idx int, err error) *TokenizerError {
-	var sb strings.Builder
-	lineNo, line, newIdx := findLine(input, idx)
-	linePrefix := fmt.Sprintf("line %d: ", lineNo)
-	sb.WriteString(linePrefix)
-	sb.WriteString(line + "\n")
-	spaces := strings.Repeat(" ", len(linePrefix)+newIdx)
-	sb.WriteString(spaces + "▲\n")
-	sb.WriteString(spaces + "└── %s")
+	src := Source{Text: input, FirstLine: 1, TabWidth: column.DefaultTabWidth}
+	return NewSourceError(src, DefaultContext, err, Label{From: idx, To: idx})
+}
 
-	s := fmt.Sprintf(sb.String(), err.Error())
-	return &TokenizerError{Msg: s, Err: err}
+// NewSourceError returns err pointing at the text marked by primary, with
+// secondary labels marking related places
+func NewSourceError(src Source, context int, err error, primary Label, secondary ...Label) *TokenizerError {
+	return &TokenizerError{
+		Err:     err,
+		Src:     src,
+		Labels:  append([]Label{primary}, secondary...),
+		Context: context,
+	}
 }
 
 func (e *TokenizerError) Error() string {
-	return e.Msg
+	r := e.Renderer
+	if r == nil {
+		r = SnippetRenderer{}
+	}
+	return r.Render(e)
 }
 
 func (e *TokenizerError) Unwrap() error {
 	return e.Err
 }
 
-func findLine(input string, idx int) (lineNo int, line string, newIdx int) {
-	lineStartIdx := strings.LastIndex(input[:idx], "\n")
-	if lineStartIdx == -1 {
-		lineStartIdx = 0
-	} else {
-		lineStartIdx += 1
-	}
-	lineEndIdx := strings.Index(input[idx:], "\n")
-	if lineEndIdx == -1 {
-		lineEndIdx = len(input)
-	} else {
-		lineEndIdx = idx + lineEndIdx
-	}
-	lineNo = strings.Count(input[:lineStartIdx], "\n") + 1
-	line = input[lineStartIdx:lineEndIdx]
-	newIdx = idx - lineStartIdx
-	for _, r := range []rune(line[:newIdx]) {
-		runeLen := utf8.RuneLen(r)
-		if runeLen > 1 {
-			newIdx -= runeLen - 1
-		}
-	}
-	return lineNo, line, newIdx
+// Find returns the first TokenizerError in err's chain
+func Find(err error) (*TokenizerError, bool) {
+	var te *TokenizerError
+	found := stderrors.As(err, &te)
+	return te, found
+}
+
+// MacroPanic is a panic raised by a macro, recovered so that a bad macro
+// fails the template rather than the whole generator
+type MacroPanic struct {
+	Macro string
+	Value any
+}
+
+func (e *MacroPanic) Error() string {
+	return fmt.Sprintf("macro %q panicked: %v", e.Macro, e.Value)
+}
+
+// Unwrap returns the value the macro panicked with if it was an error
+func (e *MacroPanic) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
 }
@@ -23,9 +23,11 @@ func TestTokenizerError_ShowFailureContext(t *testing.T) {
 			################################################################################
 			# error with context
 			################################################################################
-			line 1: foo ◊(1 + 2 bar
-			             ▲
-			             └── did not find matched ')'
+			did not find matched ')'
+			  ┌─ 1:8
+			  │
+			1 │ foo ◊(1 + 2 bar
+			  │      ^
 			`)
 	})
 	t.Run("on multiple line", func(t *testing.T) {
@@ -48,57 +50,135 @@ will fail
 			################################################################################
 			# error with context
 			################################################################################
-			line 4: ◊} else 
-			         ▲
-			         └── no open brace found
+			no open brace found
+			  ┌─ 4:4
+			  │
+			3 │ nope
+			4 │ ◊} else
+			  │  ^
+			5 │ will fail
 			`)
 	})
-}
-
-func Test_findLine(t *testing.T) {
-	t.Run("one line", func(t *testing.T) {
-		lineNo, line, idx := findLine(`only one line`, 5)
-		c := ic.New(t)
-		c.PrintSection("line")
-		c.Printf("line %d: %s\n", lineNo, line)
+	t.Run("with tabs, CRLF and a BOM", func(t *testing.T) {
+		input := "\uFEFF◊{\r\n\tif ◊x {\r\n\t\tbad }\r\n"
 
-		c.PrintSection("idx")
-		c.Println(idx)
+		e := fmt.Errorf("found a marker in code")
+		idx := strings.Index(input, "◊x")
+		e1 := NewTokenizerError(input, idx, e)
+		e2 := NewSourceError(Source{Text: input, FirstLine: 1, TabWidth: 2}, 0, e, Label{From: idx, To: idx + len("◊x")})
+		e3 := NewTokenizerError(input, strings.Index(input, "\uFEFF"), e)
 
+		c := ic.New(t)
+		c.PrintSection("default tab width")
+		c.Println(e1)
+		c.PrintSection("tab width 2")
+		c.Println(e2)
+		c.PrintSection("at the BOM")
+		c.Println(e3)
 		c.Expect(`
 			################################################################################
-			# line
+			# default tab width
 			################################################################################
-			line 1: only one line
+			found a marker in code
+			  ┌─ 2:5
+			  │
+			1 │ ◊{
+			2 │         if ◊x {
+			  │            ^
+			3 │                 bad }
 			################################################################################
-			# idx
+			# tab width 2
 			################################################################################
-			5
-			`)
-	})
-	t.Run("two lines", func(t *testing.T) {
-		input := `
-this line is one
-this line is two
-`[1:]
-		globalIdx := strings.Index(input, "two")
-		lineNo, line, idx := findLine(input, globalIdx)
-		c := ic.New(t)
-		c.PrintSection("line")
-		c.Printf("line %d: %s\n", lineNo, line)
-
-		c.PrintSection("idx")
-		c.Println(idx)
-
-		c.Expect(`
-			################################################################################
-			# line
+			found a marker in code
+			  ┌─ 2:5
+			  │
+			2 │   if ◊x {
+			  │      ^^
 			################################################################################
-			line 2: this line is two
+			# at the BOM
 			################################################################################
-			# idx
-			################################################################################
-			13
+			found a marker in code
+			  ┌─ 1:1
+			  │
+			1 │ ◊{
+			  │ ^
+			2 │         if ◊x {
 			`)
 	})
 }
+
+func TestTokenizerError_Labels(t *testing.T) {
+	input := `
+line 1
+◊.if x {◊
+  line 3
+  line 4
+  line 5
+  line 6
+  line 7
+  line 8`[1:]
+	open := strings.Index(input, "{◊")
+	e := fmt.Errorf(`did not find "◊}"`)
+
+	c := ic.New(t)
+	c.PrintSection("ending nearby")
+	c.Println(NewSourceError(Source{Name: "test.◊", Text: input, FirstLine: 1}, 1, e,
+		Label{From: open, To: strings.Index(input, "3") + 1},
+		Label{From: open, To: open + len("{◊"), Msg: "block opened here"},
+	))
+	c.PrintSection("ending far away")
+	c.Println(NewSourceError(Source{Name: "test.◊", Text: input, FirstLine: 1}, 1, e,
+		Label{From: open, To: len(input)},
+		Label{From: open, To: open + len("{◊"), Msg: "block opened here"},
+		Label{From: len(input), To: len(input), Msg: "input ended here"},
+	))
+	c.PrintSection("released text")
+	released := strings.Index(input, "  line 5")
+	c.Println(NewSourceError(Source{Name: "test.◊", Text: input[released:], Base: released, FirstLine: 5}, 0, e,
+		Label{From: open, To: len(input)},
+		Label{From: len(input), To: len(input), Msg: "input ended here"},
+	))
+	c.Expect(`
+		################################################################################
+		# ending nearby
+		################################################################################
+		did not find "◊}"
+		  ┌─ test.◊:2:10
+		  │
+		1 │ line 1
+		2 │ ◊.if x {◊
+		  │        ^^
+		  │        -- block opened here
+		3 │   line 3
+		  │ ^^^^^^^^
+		4 │   line 4
+		################################################################################
+		# ending far away
+		################################################################################
+		did not find "◊}"
+		  ┌─ test.◊:2:10
+		  │
+		1 │ line 1
+		2 │ ◊.if x {◊
+		  │        ^^
+		  │        -- block opened here
+		3 │   line 3
+		  ┆
+		7 │   line 7
+		8 │   line 8
+		  │ ^^^^^^^^
+		  │         - input ended here
+		################################################################################
+		# released text
+		################################################################################
+		did not find "◊}"
+		  ┌─ test.◊:5:1
+		  │
+		5 │   line 5
+		  │ ^^^^^^^^
+		  ┆
+		8 │   line 8
+		  │ ^^^^^^^^
+		  │         - input ended here
+		`)
+}
@@ -0,0 +1,237 @@
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
+)
+
+// Renderer turns a TokenizerError into the text it's shown as
+type Renderer interface {
+	Render(e *TokenizerError) string
+}
+
+// SnippetRenderer shows the error's message followed by the lines of the
+// template it's in, with the text in error underlined with "^" and any
+// related places with "-". It draws its margin with box drawing characters
+// unless ASCII is set, and colours its output for a terminal when Color is.
+type SnippetRenderer struct {
+	ASCII bool
+	Color bool
+}
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[1;31m"
+	ansiBlue  = "\x1b[1;34m"
+)
+
+func (r SnippetRenderer) Render(e *TokenizerError) string {
+	loc, bar, gap := "┌─", "│", "┆"
+	if r.ASCII {
+		loc, bar, gap = "-->", "|", ":"
+	}
+	paint := func(s, code string) string {
+		if !r.Color || s == "" {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	var sb strings.Builder
+	sb.WriteString(paint(e.Err.Error(), ansiBold) + "\n")
+	s := newSnippet(e.Src)
+	labels := s.clampAll(e.Labels)
+
+	// The lines to show, with a gap wherever the next isn't straight after
+	shown := make(map[int]bool)
+	for _, l := range labels {
+		for _, at := range []int{s.lineOf(l.From), s.lineOf(l.To)} {
+			for n := at - e.Context; n <= at+e.Context; n++ {
+				if n >= 0 && n < len(s.lines) {
+					shown[n] = true
+				}
+			}
+		}
+	}
+	var lines []int
+	for n := range shown {
+		lines = append(lines, n)
+	}
+	sort.Ints(lines)
+
+	gutter := strings.Repeat(" ", len(strconv.Itoa(s.lineNo(lines[len(lines)-1]))))
+	margin := func(lineNo string) string {
+		return paint(lineNo+" "+bar, ansiBlue)
+	}
+	sb.WriteString(paint(gutter+" "+loc, ansiBlue) + " " + s.location(e.Src.Name, labels[0]) + "\n")
+	sb.WriteString(margin(gutter) + "\n")
+	for n, lineIdx := range lines {
+		if n > 0 && lineIdx != lines[n-1]+1 {
+			sb.WriteString(paint(gutter+" "+gap, ansiBlue) + "\n")
+		}
+		line := s.lines[lineIdx]
+		text := strings.TrimRight(column.Expand(s.text[line.start:line.end], s.tabWidth), " ")
+		lineNo := fmt.Sprintf("%*d", len(gutter), s.lineNo(lineIdx))
+		if text == "" {
+			sb.WriteString(margin(lineNo) + "\n")
+		} else {
+			sb.WriteString(margin(lineNo) + " " + text + "\n")
+		}
+		for labelIdx, l := range labels {
+			// A label spanning lines is underlined on its first and last
+			from, to := s.lineOf(l.From), s.lineOf(l.To)
+			if lineIdx != from && lineIdx != to {
+				continue
+			}
+			start, end := line.start, line.end
+			if lineIdx == from {
+				start = l.From
+			}
+			if lineIdx == to {
+				end = l.To
+			}
+			lineText := s.text[line.start:line.end]
+			_, startCol := column.Of(lineText, start-line.start, s.tabWidth)
+			_, endCol := column.Of(lineText, end-line.start, s.tabWidth)
+			mark, code := "-", ansiBlue
+			if labelIdx == 0 {
+				mark, code = "^", ansiRed
+			}
+			row := strings.Repeat(mark, maxInt(endCol-startCol, 1))
+			if lineIdx == to && l.Msg != "" {
+				row += " " + l.Msg
+			}
+			sb.WriteString(margin(gutter) + " " + strings.Repeat(" ", startCol-1) + paint(row, code) + "\n")
+		}
+	}
+	if help := didYouMean(e.Suggestions); help != "" {
+		sb.WriteString(paint(gutter+" =", ansiBlue) + " " + paint("help:", ansiBold) + " " + help + "\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// CompactRenderer shows the error on a single line as file:line:col: message,
+// the form editors and tools such as Vim's errorformat understand
+type CompactRenderer struct{}
+
+func (CompactRenderer) Render(e *TokenizerError) string {
+	s := newSnippet(e.Src)
+	msg := strings.ReplaceAll(e.Err.Error(), "\n", " ")
+	if help := didYouMean(e.Suggestions); help != "" {
+		msg += "; " + help
+	}
+	return s.location(e.Src.Name, s.clampAll(e.Labels)[0]) + ": " + msg
+}
+
+// didYouMean returns a hint listing the text of up to three suggestions, or
+// nothing if there are none
+func didYouMean(suggestions []Suggestion) string {
+	var names []string
+	for _, sug := range suggestions {
+		if len(names) == 3 {
+			break
+		}
+		names = append(names, strconv.Quote(sug.Text))
+	}
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("did you mean %s?", names[0])
+	default:
+		return fmt.Sprintf("did you mean %s or %s?", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	}
+}
+
+// snippet splits the text of a Source into lines
+type snippet struct {
+	text      string
+	base      int
+	firstLine int
+	tabWidth  int
+	lines     []lineSpan
+}
+
+// lineSpan holds the offsets into the snippet's text of a line, not
+// including its "\n"
+type lineSpan struct {
+	start, end int
+}
+
+func newSnippet(src Source) *snippet {
+	s := &snippet{text: src.Text, base: src.Base, firstLine: src.FirstLine, tabWidth: src.TabWidth}
+	var start int
+	if src.FirstLine == 1 && strings.HasPrefix(src.Text, column.BOM) {
+		// Only the start of the template can hold a BOM, which isn't part of
+		// the first line
+		start = len(column.BOM)
+	}
+	for {
+		nl := strings.IndexByte(src.Text[start:], '\n')
+		if nl == -1 {
+			s.lines = append(s.lines, lineSpan{start, len(src.Text)})
+			return s
+		}
+		s.lines = append(s.lines, lineSpan{start, start + nl})
+		start += nl + 1
+	}
+}
+
+// clampAll returns labels with their offsets made relative to the snippet's
+// text and kept within its lines
+func (s *snippet) clampAll(labels []Label) []Label {
+	bound := func(idx int) int {
+		idx -= s.base
+		if idx < s.lines[0].start {
+			return s.lines[0].start
+		}
+		if idx > len(s.text) {
+			return len(s.text)
+		}
+		return idx
+	}
+	clamped := make([]Label, len(labels))
+	for n, l := range labels {
+		l.From, l.To = bound(l.From), bound(l.To)
+		if l.To < l.From {
+			l.To = l.From
+		}
+		clamped[n] = l
+	}
+	return clamped
+}
+
+// location returns where l starts as name:line:col, with the column in bytes
+// like the rest of the Go tools. It's just line:col without a name.
+func (s *snippet) location(name string, l Label) string {
+	lineIdx := s.lineOf(l.From)
+	loc := fmt.Sprintf("%d:%d", s.lineNo(lineIdx), l.From-s.lines[lineIdx].start+1)
+	if name == "" {
+		return loc
+	}
+	return name + ":" + loc
+}
+
+// lineOf returns the index of the line holding offset idx. The "\n" ending a
+// line belongs to it.
+func (s *snippet) lineOf(idx int) int {
+	return sort.Search(len(s.lines)-1, func(n int) bool {
+		return idx <= s.lines[n].end
+	})
+}
+
+func (s *snippet) lineNo(lineIdx int) int {
+	return s.firstLine + lineIdx
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
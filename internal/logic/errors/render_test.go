@@ -0,0 +1,121 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+)
+
+func TestRenderers(t *testing.T) {
+	input := "◊.for x {◊\n\t◊(x\n◊}"
+	open := strings.Index(input, "(x")
+	e := NewSourceError(Source{Name: "test.◊", Text: input, FirstLine: 1, TabWidth: 4}, 1, fmt.Errorf("did not find matched ')'"),
+		Label{From: open, To: len(input)},
+		Label{From: open, To: open + 1, Msg: "opened here"},
+	)
+
+	c := ic.New(t)
+	for _, r := range []Renderer{SnippetRenderer{}, SnippetRenderer{ASCII: true}, SnippetRenderer{Color: true}, CompactRenderer{}} {
+		c.PrintSection(fmt.Sprintf("%#v", r))
+		e.Renderer = r
+		// Show the escape codes rather than colouring the test output
+		c.Println(strings.ReplaceAll(e.Error(), "\x1b", `\e`))
+	}
+	c.Expect(`
+		################################################################################
+		# errors.SnippetRenderer{ASCII:false, Color:false}
+		################################################################################
+		did not find matched ')'
+		  ┌─ test.◊:2:5
+		  │
+		1 │ ◊.for x {◊
+		2 │     ◊(x
+		  │      ^^
+		  │      - opened here
+		3 │ ◊}
+		  │ ^^
+		################################################################################
+		# errors.SnippetRenderer{ASCII:true, Color:false}
+		################################################################################
+		did not find matched ')'
+		  --> test.◊:2:5
+		  |
+		1 | ◊.for x {◊
+		2 |     ◊(x
+		  |      ^^
+		  |      - opened here
+		3 | ◊}
+		  | ^^
+		################################################################################
+		# errors.SnippetRenderer{ASCII:false, Color:true}
+		################################################################################
+		\e[1mdid not find matched ')'\e[0m
+		\e[1;34m  ┌─\e[0m test.◊:2:5
+		\e[1;34m  │\e[0m
+		\e[1;34m1 │\e[0m ◊.for x {◊
+		\e[1;34m2 │\e[0m     ◊(x
+		\e[1;34m  │\e[0m      \e[1;31m^^\e[0m
+		\e[1;34m  │\e[0m      \e[1;34m- opened here\e[0m
+		\e[1;34m3 │\e[0m ◊}
+		\e[1;34m  │\e[0m \e[1;31m^^\e[0m
+		################################################################################
+		# errors.CompactRenderer{}
+		################################################################################
+		test.◊:2:5: did not find matched ')'
+		`)
+}
+
+func TestRenderers_suggestions(t *testing.T) {
+	input := "◊.fro x {◊◊}"
+	name := strings.Index(input, "fro")
+	e := NewSourceError(Source{Text: input, FirstLine: 1}, 1, fmt.Errorf(`unknown macro "fro"`),
+		Label{From: name, To: name + len("fro")},
+	)
+
+	c := ic.New(t)
+	for _, suggestions := range [][]string{{"for"}, {"for", "fr"}, {"for", "fr", "frog", "from"}} {
+		e.Suggestions = nil
+		for _, text := range suggestions {
+			e.Suggestions = append(e.Suggestions, Suggestion{From: name, To: name + len("fro"), Text: text})
+		}
+		c.PrintSection(fmt.Sprintf("%q", suggestions))
+		for _, r := range []Renderer{SnippetRenderer{}, CompactRenderer{}} {
+			e.Renderer = r
+			c.Println(e.Error())
+		}
+	}
+	c.Expect(`
+		################################################################################
+		# ["for"]
+		################################################################################
+		unknown macro "fro"
+		  ┌─ 1:5
+		  │
+		1 │ ◊.fro x {◊◊}
+		  │   ^^^
+		  = help: did you mean "for"?
+		1:5: unknown macro "fro"; did you mean "for"?
+		################################################################################
+		# ["for" "fr"]
+		################################################################################
+		unknown macro "fro"
+		  ┌─ 1:5
+		  │
+		1 │ ◊.fro x {◊◊}
+		  │   ^^^
+		  = help: did you mean "for" or "fr"?
+		1:5: unknown macro "fro"; did you mean "for" or "fr"?
+		################################################################################
+		# ["for" "fr" "frog" "from"]
+		################################################################################
+		unknown macro "fro"
+		  ┌─ 1:5
+		  │
+		1 │ ◊.fro x {◊◊}
+		  │   ^^^
+		  = help: did you mean "for", "fr" or "frog"?
+		1:5: unknown macro "fro"; did you mean "for", "fr" or "frog"?
+		`)
+}
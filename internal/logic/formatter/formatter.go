@@ -0,0 +1,170 @@
+// Package formatter lays out the Go code in a template the way gofumpt would,
+// leaving its content alone.
+package formatter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/internal/infra/go_format"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_for"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_if"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+var elseRegex = regexp.MustCompile(`^}\s*else\s*`)
+
+// edit replaces the text between start and end with s
+type edit struct {
+	start, end int
+	s          string
+}
+
+// Format rewrites src, the template toks were read from. The code in ◊{ } and
+// ◊^{ } blocks and the heads of ◊.if and ◊.for macros is formatted, and
+// anything else is left as it is. Code that doesn't parse on its own is also
+// left as it is.
+func Format(src string, toks []*token.Token) string {
+	var edits []edit
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		if tok.TT != token.TTcodeGlobalBlock && tok.TT != token.TTcodeLocalBlock {
+			continue
+		}
+		if isMacroHead(tok) {
+			if formatted, ok := formatHeadToken(tok); ok && formatted != tok.Slc.S {
+				edits = append(edits, edit{tok.Slc.Start.Idx, tok.Slc.End.Idx, formatted})
+			}
+			continue
+		}
+		// The tokenizer splits a block into a token per line, so gather the
+		// rest of them up
+		j := i + 1
+		for j < len(toks) && toks[j].TT == tok.TT && !isMacroHead(toks[j]) &&
+			strings.TrimSpace(src[toks[j-1].Slc.End.Idx:toks[j].Slc.Start.Idx]) == "" {
+			j++
+		}
+		open, close, ok := blockBounds(src, tok.Slc.Start.Idx, toks[j-1].Slc.End.Idx)
+		i = j - 1
+		if !ok {
+			continue
+		}
+		format := formatStmts
+		if tok.TT == token.TTcodeGlobalBlock {
+			format = formatDecls
+		}
+		code := src[open+1 : close]
+		if formatted, ok := formatBlock(code, lineIndent(src, open), format); ok && formatted != code {
+			edits = append(edits, edit{open + 1, close, formatted})
+		}
+	}
+
+	var sb strings.Builder
+	var last int
+	for _, e := range edits {
+		sb.WriteString(src[last:e.start])
+		sb.WriteString(e.s)
+		last = e.end
+	}
+	sb.WriteString(src[last:])
+	return sb.String()
+}
+
+func isMacroHead(tok *token.Token) bool {
+	return macro_if.Branch.Has(tok) || macro_for.Vars.Has(tok) || macro_for.Else.Has(tok)
+}
+
+func formatHeadToken(tok *token.Token) (string, bool) {
+	if macro_for.Else.Has(tok) {
+		return "} else {", true
+	}
+	if helper, found := macro_for.Helper.Get(tok); found {
+		head, ok := formatHead(macro_for.Clause(tok.Slc.S))
+		return "for[" + helper + "]" + strings.TrimPrefix(head, "for"), ok
+	}
+	kind, _ := macro_if.Branch.Get(tok)
+	switch kind {
+	case "else if":
+		head, ok := formatHead(elseRegex.ReplaceAllString(strings.TrimSpace(tok.Slc.S), ""))
+		return "} else " + head, ok
+	case "else":
+		return "} else {", true
+	case "end":
+		return tok.Slc.S, false
+	default:
+		return formatHead(tok.Slc.S)
+	}
+}
+
+// blockBounds returns the offsets of the braces around the code between start
+// and end, if it's inside a block
+func blockBounds(src string, start, end int) (open, close int, ok bool) {
+	open = len(strings.TrimRight(src[:start], " \t\n")) - 1
+	close = end + len(src[end:]) - len(strings.TrimLeft(src[end:], " \t\n"))
+	if open < 0 || close >= len(src) || src[open] != '{' || src[close] != '}' {
+		return 0, 0, false
+	}
+	return open, close, true
+}
+
+// lineIndent returns the spaces and tabs starting the line holding offset idx
+func lineIndent(src string, idx int) string {
+	line := src[strings.LastIndex(src[:idx], "\n")+1:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// formatBlock formats the code inside a ◊{ } or ◊^{ } block, whose opening
+// line is indented by indent. Code kept on one line is padded with a space
+// either side, while code already spread over several lines, or which gofumpt
+// spreads over several, starts on a line of its own. Those lines, and the
+// closing brace, are indented to line up with the opening line.
+func formatBlock(code, indent string, format func(code string) ([]string, bool)) (string, bool) {
+	if strings.TrimSpace(code) == "" {
+		return code, false
+	}
+	lines, ok := format(code)
+	if !ok {
+		return code, false
+	}
+	if len(lines) == 1 && !strings.Contains(code, "\n") {
+		return " " + lines[0] + " ", true
+	}
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return "\n" + strings.Join(lines, "\n") + "\n" + indent, true
+}
+
+// formatHead formats code opening a block, such as `if x {`
+func formatHead(code string) (string, bool) {
+	lines, ok := formatStmts(code + "\n}")
+	if !ok || len(lines) != 2 || !strings.HasSuffix(lines[0], "{") {
+		return code, false
+	}
+	return lines[0], true
+}
+
+func formatStmts(code string) ([]string, bool) {
+	const prefix = "package p\n\nfunc _() {\n"
+	formatted, err := go_format.Format(prefix + code + "\n}\n")
+	if err != nil || !strings.HasPrefix(formatted, prefix) {
+		return nil, false
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(formatted, prefix), "}\n")
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimPrefix(l, "\t")
+	}
+	return lines, true
+}
+
+func formatDecls(code string) ([]string, bool) {
+	const prefix = "package p\n\n"
+	formatted, err := go_format.Format(prefix + code + "\n")
+	if err != nil || !strings.HasPrefix(formatted, prefix) {
+		return nil, false
+	}
+	return strings.Split(strings.TrimSuffix(strings.TrimPrefix(formatted, prefix), "\n"), "\n"), true
+}
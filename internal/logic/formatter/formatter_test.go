@@ -0,0 +1,118 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template"
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+)
+
+func TestFormat(t *testing.T) {
+	t.Run("blocks", func(t *testing.T) {
+		s := `
+◊^{import   "strings"}
+◊^{
+func shout(s string)string{return strings.ToUpper(s)}
+}
+◊{x:=1}
+◊{   }
+◊{
+y:=x+1
+z:=y*2
+}
+◊{ x := }
+  text   with   spacing ◊x`[1:]
+
+		c := ic.New(t)
+		c.Print(format(t, s))
+		c.Expect(`
+			◊^{ import "strings" }
+			◊^{
+			func shout(s string) string { return strings.ToUpper(s) }
+			}
+			◊{ x := 1 }
+			◊{   }
+			◊{
+			y := x + 1
+			z := y * 2
+			}
+			◊{ x := }
+			  text   with   spacing ◊x`)
+	})
+	t.Run("indented blocks", func(t *testing.T) {
+		s := `
+<ul>
+  ◊.for _, v := range vals {◊
+    ◊{
+      if v>1{
+    total+=v
+      }
+    }
+    <li>◊v</li>
+  ◊}
+	◊{
+	n:=len(vals)
+	}
+</ul>`[1:]
+
+		c := ic.New(t)
+		got := format(t, s)
+		c.Print(got)
+		c.Expect(`
+			<ul>
+			  ◊.for _, v := range vals {◊
+			    ◊{
+			    if v > 1 {
+			    	total += v
+			    }
+			    }
+			    <li>◊v</li>
+			  ◊}
+				◊{
+				n := len(vals)
+				}
+			</ul>`)
+		if again := format(t, got); again != got {
+			t.Errorf("formatting again got %q want %q", again, got)
+		}
+	})
+	t.Run("macro heads", func(t *testing.T) {
+		s := `
+◊.for  i:=0;i<3;i++  {◊
+  ◊.if i==0 {◊zero◊}  else  if  i==1{◊one◊}else   {◊many◊}
+◊}
+◊.for _,v:=range vals{◊◊v◊}
+◊.for[loop] _,v:=range vals{◊◊v◊}else  {◊none◊}`[1:]
+
+		c := ic.New(t)
+		c.Print(format(t, s))
+		c.Expect(`
+			◊.for i := 0; i < 3; i++ {◊
+			  ◊.if i == 0 {◊zero◊} else if i == 1 {◊one◊} else {◊many◊}
+			◊}
+			◊.for _, v := range vals {◊◊v◊}
+			◊.for[loop] _, v := range vals {◊◊v◊} else {◊none◊}`)
+	})
+	t.Run("already formatted", func(t *testing.T) {
+		s := `
+◊{ x := 1 }
+◊.if x > 1 {◊big◊} else {◊small◊}`[1:]
+
+		got := format(t, s)
+		if got != s {
+			t.Errorf("got %q want %q", got, s)
+		}
+	})
+}
+
+func format(t *testing.T, s string) string {
+	t.Helper()
+	lt := lozenge_template.New(nil, lozenge_template.NewParserConfig())
+	toks, err := lt.Tokenize(&main_handler.MainHandler{}, input.NewInput("test", s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Format(s, toks)
+}
@@ -0,0 +1,218 @@
+package macro_define
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"regexp"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+func New() *MacroDefine {
+	return &MacroDefine{}
+}
+
+// MacroDefine declares a component, a piece of the template that can be
+// written out again wherever it's called. Its body writes whatever it's
+// called with at each ◊.slot:
+//
+//	◊.define card(title string) {◊<div><h2>◊title</h2>◊.slot</div>◊}
+//	◊.card("Hi") {◊<p>body</p>◊}
+//	◊.card("Empty")
+//
+// A component becomes a Go func taking its parameters and a func slot
+// writing its body, so it can only be called after its definition, which
+// must be at the top of the template rather than inside a block.
+type MacroDefine struct{}
+
+// Signature describes a component, as declared by its ◊.define
+type Signature struct {
+	Name string
+	// Params is the Go parameter list, without its brackets
+	Params string
+	// NParams is how many parameters are in the list
+	NParams int
+}
+
+// Component holds the Signature declared by an ◊.define's head token
+var Component = token.NewKey[Signature]("define.component")
+
+// Args holds the arguments, brackets and all, given by the head token of a
+// component's call
+var Args = token.NewKey[input.Slice]("define.args")
+
+var defineRegex = regexp.MustCompile(`^define[ \t]+`)
+
+func (m MacroDefine) Name() string {
+	return "define"
+}
+
+func (m MacroDefine) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	if _, found := in.ConsumeRegexp(defineRegex); !found {
+		in.ConsumeString(m.Name())
+		return nil, in.ErrorHere(fmt.Errorf("expected component name"))
+	}
+	var head *token.Token
+	head, err = ct.NextTokenCodeUntilOpenBraceLoz(in)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := parseSignature(head.Slc.S)
+	if err != nil {
+		return nil, in.ErrorAt(head.Slc.Start.Idx, head.Slc.End.Idx, err)
+	}
+	Component.Set(head, sig)
+	toks = append(toks, head)
+
+	subTokens, err := ct.ReadTokensUntil(in, "◊}")
+	if err != nil {
+		return nil, err
+	}
+	toks = append(toks, subTokens...)
+	in.ShiftSlice('◊')
+	toks = append(toks, token.NewToken(token.TTcodeLocalBlock, in.ShiftSlice('}')))
+
+	// Defined once its body is read, as a component can't call itself
+	if !ct.DefineMacro(&component{sig: sig}) {
+		nameStart := head.Slc.Start.Idx
+		return nil, in.ErrorAt(nameStart, nameStart+len(sig.Name), fmt.Errorf("%q is already defined", sig.Name))
+	}
+	return toks, nil
+}
+
+func (m MacroDefine) ParseNode(tp interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	branches, closeTok, rest, err := tp.ParseBlock(toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	if len(branches) > 1 {
+		return nil, toks, fmt.Errorf("define: unexpected %q", branches[1].Head.S)
+	}
+	sig, _ := Component.Get(toks[0])
+	return &ast.Component{
+		Macro:  macro.Slc,
+		Name:   sig.Name,
+		Params: sig.Params,
+		Head:   branches[0].Head,
+		Body:   branches[0].Body,
+		Close:  closeTok.Slc,
+	}, rest, nil
+}
+
+func (m MacroDefine) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	return toks, nil
+}
+
+// parseSignature parses the head of an ◊.define, such as
+// `card(title string) {`, as the declaration of a Go func
+func parseSignature(head string) (Signature, error) {
+	decl := strings.TrimSuffix(strings.TrimSpace(head), "{")
+	src := "package p\nfunc " + decl + "{}\n"
+	var fn *goast.FuncDecl
+	if f, err := goparser.ParseFile(gotoken.NewFileSet(), "", src, 0); err == nil && len(f.Decls) == 1 {
+		fn, _ = f.Decls[0].(*goast.FuncDecl)
+	}
+	if fn == nil || fn.Recv != nil {
+		return Signature{}, fmt.Errorf("expected component name and parameters, found %q", strings.TrimSpace(decl))
+	}
+	name := fn.Name.Name
+	switch {
+	case fn.Type.TypeParams != nil:
+		return Signature{}, fmt.Errorf("component %s can't have type parameters", name)
+	case fn.Type.Results != nil:
+		return Signature{}, fmt.Errorf("component %s can't return values", name)
+	}
+	sig := Signature{Name: name}
+	for _, field := range fn.Type.Params.List {
+		if _, isVariadic := field.Type.(*goast.Ellipsis); isVariadic {
+			return Signature{}, fmt.Errorf("component %s can't be variadic", name)
+		}
+		sig.NParams += maxInt(len(field.Names), 1)
+	}
+	// The offsets are into src, where the file starts at 1
+	sig.Params = src[fn.Type.Params.Opening : fn.Type.Params.Closing-1]
+	return sig, nil
+}
+
+// component is the macro calling a component defined by an ◊.define
+type component struct {
+	sig Signature
+}
+
+func (c *component) Name() string {
+	return c.sig.Name
+}
+
+func (c *component) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	ma, err := ct.ReadMacroArgs(in, c.sig.Name)
+	if err != nil {
+		return nil, err
+	}
+	if ma.List.Len() == 0 {
+		return nil, in.ErrorAt(ma.Name.End.Idx, ma.Name.End.Idx, fmt.Errorf("expected arguments to component %s", c.sig.Name))
+	}
+	if err = c.checkArgs(in, ma); err != nil {
+		return nil, err
+	}
+	head := token.NewToken(token.TTcodeLocalBlock, ma.List)
+	Args.Set(head, ma.List)
+	toks = append(toks, head)
+	if !ma.HasBody() {
+		return toks, nil
+	}
+	// The head opens a block, like those of other block macros
+	head.Slc = in.SliceAt(ma.List.Start.Idx, ma.Open.Start.Idx+len("{"))
+	toks = append(toks, ma.Body...)
+	return append(toks, token.NewToken(token.TTcodeLocalBlock, in.SliceAt(ma.Close.End.Idx-len("}"), ma.Close.End.Idx))), nil
+}
+
+// checkArgs returns an error if the component wasn't called with as many
+// arguments as it has parameters, or was given any as key=value
+func (c *component) checkArgs(in *input.Input, ma *interfaces.MacroArgs) error {
+	for _, a := range ma.Args {
+		if a.Key.Len() > 0 {
+			return in.ErrorAt(a.Key.Start.Idx, a.Value.End.Idx, fmt.Errorf("component %s takes no key=value arguments", c.sig.Name))
+		}
+	}
+	have, want := len(ma.Args), c.sig.NParams
+	switch {
+	case have > want:
+		return in.ErrorAt(ma.List.Start.Idx, ma.List.End.Idx, fmt.Errorf("too many arguments in call to %s: have %d, want %d", c.sig.Name, have, want))
+	case have < want:
+		return in.ErrorAt(ma.List.Start.Idx, ma.List.End.Idx, fmt.Errorf("not enough arguments in call to %s: have %d, want %d", c.sig.Name, have, want))
+	}
+	return nil
+}
+
+func (c *component) ParseNode(tp interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	head := toks[0]
+	args, _ := Args.Get(head)
+	if !strings.HasSuffix(head.Slc.S, "{") {
+		return &ast.Call{Name: macro.Slc, Args: args}, toks[1:], nil
+	}
+	branches, closeTok, rest, err := tp.ParseBlock(toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	if len(branches) > 1 {
+		return nil, toks, fmt.Errorf("%s: unexpected %q", c.sig.Name, branches[1].Head.S)
+	}
+	return &ast.Call{Name: macro.Slc, Args: args, Body: branches[0].Body, Close: closeTok.Slc}, rest, nil
+}
+
+func (c *component) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	return toks, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,48 @@
+package macro_define
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+)
+
+func TestParseSignature(t *testing.T) {
+	c := ic.New(t)
+	for _, head := range []string{
+		"card() {",
+		"card(title string) {",
+		"card(title, sub string, n int) {",
+		"card(string, int) {",
+		"card(\n\ttitle string,\n) {",
+		"card(items ...string) {",
+		"card() error {",
+		"card[T any](v T) {",
+		"(c Card) card() {",
+		"card {",
+	} {
+		sig, err := parseSignature(head)
+		c.Printf("%q\n\tname=%q params=%q n=%d err=%v\n", head, sig.Name, sig.Params, sig.NParams, err)
+	}
+	c.Expect(`
+		"card() {"
+			name="card" params="" n=0 err=<nil>
+		"card(title string) {"
+			name="card" params="title string" n=1 err=<nil>
+		"card(title, sub string, n int) {"
+			name="card" params="title, sub string, n int" n=3 err=<nil>
+		"card(string, int) {"
+			name="card" params="string, int" n=2 err=<nil>
+		"card(\n\ttitle string,\n) {"
+			name="card" params="\n\ttitle string,\n" n=1 err=<nil>
+		"card(items ...string) {"
+			name="" params="" n=0 err=component card can't be variadic
+		"card() error {"
+			name="" params="" n=0 err=component card can't return values
+		"card[T any](v T) {"
+			name="" params="" n=0 err=component card can't have type parameters
+		"(c Card) card() {"
+			name="" params="" n=0 err=expected component name and parameters, found "(c Card) card()"
+		"card {"
+			name="" params="" n=0 err=expected component name and parameters, found "card"
+		`)
+}
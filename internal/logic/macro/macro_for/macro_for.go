@@ -1,8 +1,16 @@
 package macro_for
 
 import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"regexp"
+	"strings"
+
 	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
@@ -10,8 +18,39 @@ func New() *MacroFor {
 	return &MacroFor{}
 }
 
+// MacroFor writes its body once for each time round the loop, and its
+// else branch, if it has one, when the body never ran:
+//
+//	◊.for _, v := range vals {◊<li>◊v</li>◊} else {◊<p>none</p>◊}
+//
+// A range loop can name a helper in brackets holding the loop's Index,
+// counting from 0, whether it's Even or Odd, and whether this is the First or
+// Last time round, and Len, how many times it goes round. It works with
+// anything that can be ranged over, channels and integers included. Len and
+// Last aren't known until the loop's done, so when they're used the body is
+// only run once it has, and it can't break, continue or return.
+//
+//	◊.for[loop] _, v := range vals {◊◊loop.Index/◊loop.Len ◊v◊.sep {◊, ◊}◊}
 type MacroFor struct{}
 
+// Vars holds the variables declared by the loop's for clause, e.g. `i` and
+// `v` for `for i, v := range vals {`. Blank identifiers are left out.
+var Vars = token.NewKey[[]string]("for.vars")
+
+// Helper holds the name of the loop's helper, when its head names one
+var Helper = token.NewKey[string]("for.helper")
+
+// Range holds the expression ranged over by a loop with a helper
+var Range = token.NewKey[input.Slice]("for.range")
+
+// Else marks the code token opening the loop's else branch
+var Else = token.NewKey[bool]("for.else")
+
+var (
+	helperRegex = regexp.MustCompile(`^for\[\s*([\pL_][\pL\pN_]*)\s*\]`)
+	elseRegex   = regexp.MustCompile(`^}\s*else\s*`)
+)
+
 func (m MacroFor) Name() string {
 	return "for"
 }
@@ -24,6 +63,12 @@ func (m MacroFor) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 	if err != nil {
 		return nil, err
 	}
+	if err = setHelper(in, tok); err != nil {
+		return nil, err
+	}
+	if vars, ok := loopVars(Clause(tok.Slc.S)); ok {
+		Vars.Set(tok, vars)
+	}
 	tokens = append(tokens, tok)
 
 	var subTokens []*token.Token
@@ -36,12 +81,135 @@ func (m MacroFor) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 	}
 	in.ShiftSlice('◊')
 
+	if in.HasPrefixRegexp(elseRegex) {
+		tok, err = ct.NextTokenCodeUntilOpenBraceLoz(in)
+		if err != nil {
+			return nil, err
+		}
+		Else.Set(tok, true)
+		tokens = append(tokens, tok)
+
+		subTokens, err = ct.ReadTokensUntil(in, "◊}")
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, subTokens...)
+		in.ShiftSlice('◊')
+	}
+
 	tok = token.NewToken(token.TTcodeLocalBlock, in.ShiftSlice('}'))
 	tokens = append(tokens, tok)
 
 	return tokens, nil
 }
 
+func (m MacroFor) ParseNode(tp interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	branches, closeTok, rest, err := tp.ParseBlock(toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	var elseBranch *ast.Branch
+	for _, b := range branches[1:] {
+		if elseBranch != nil || strings.Join(strings.Fields(b.Head.S), " ") != "} else {" {
+			return nil, toks, fmt.Errorf("for: unexpected %q", b.Head.S)
+		}
+		elseBranch = b
+	}
+	head := toks[0]
+	vars, _ := Vars.Get(head)
+	helper, _ := Helper.Get(head)
+	rng, _ := Range.Get(head)
+	return &ast.For{
+		Macro:  macro.Slc,
+		Vars:   vars,
+		Helper: helper,
+		Head:   input.NewSlice(head.Slc.Name, Clause(head.Slc.S), head.Slc.Start, head.Slc.End),
+		Range:  rng,
+		Body:   branches[0].Body,
+		Else:   elseBranch,
+		Close:  closeTok.Slc,
+	}, rest, nil
+}
+
 func (m MacroFor) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
 	return toks, nil
 }
+
+// Clause returns the for clause of a loop's head, without the helper it
+// may name
+func Clause(head string) string {
+	if m := helperRegex.FindString(head); m != "" {
+		return "for" + head[len(m):]
+	}
+	return head
+}
+
+// setHelper sets the Helper and Range of tok, the head of a loop, if it
+// names a helper
+func setHelper(in *input.Input, tok *token.Token) error {
+	head := tok.Slc
+	if !strings.HasPrefix(head.S, "for[") {
+		return nil
+	}
+	m := helperRegex.FindStringSubmatch(head.S)
+	if m == nil {
+		return in.ErrorAt(head.Start.Idx, head.Start.Idx+len("for["), fmt.Errorf("expected loop helper name"))
+	}
+	from, to, isRange := rangeExpr(Clause(head.S))
+	if !isRange {
+		return in.ErrorAt(head.Start.Idx, head.End.Idx, fmt.Errorf("loop helper %s needs a range clause", m[1]))
+	}
+	// The offsets are into the clause, which starts with "for" in place of
+	// the helper
+	offset := head.Start.Idx + len(m[0]) - len("for")
+	Helper.Set(tok, m[1])
+	Range.Set(tok, in.SliceAt(offset+from, offset+to))
+	return nil
+}
+
+// rangeExpr returns the offsets into head, a for clause ending in `{`, of the
+// expression it ranges over. isRange is false if it isn't a range clause.
+func rangeExpr(head string) (from, to int, isRange bool) {
+	const prefix = "package p\nfunc _() {\n"
+	src := prefix + head + "\n}\n}\n"
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "", src, 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	stmt, isRange := f.Decls[0].(*goast.FuncDecl).Body.List[0].(*goast.RangeStmt)
+	if !isRange {
+		return 0, 0, false
+	}
+	// The file starts at 1
+	return int(stmt.X.Pos()) - 1 - len(prefix), int(stmt.X.End()) - 1 - len(prefix), true
+}
+
+// loopVars returns the variables declared by a for clause ending in `{`. ok is
+// false if the clause doesn't parse.
+func loopVars(head string) (vars []string, ok bool) {
+	src := "package p\nfunc _() {\n" + head + "\n}\n}\n"
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, false
+	}
+	add := func(exprs ...goast.Expr) {
+		for _, e := range exprs {
+			if id, isIdent := e.(*goast.Ident); isIdent && id.Name != "_" {
+				vars = append(vars, id.Name)
+			}
+		}
+	}
+	switch stmt := f.Decls[0].(*goast.FuncDecl).Body.List[0].(type) {
+	case *goast.RangeStmt:
+		if stmt.Tok == gotoken.DEFINE {
+			add(stmt.Key, stmt.Value)
+		}
+	case *goast.ForStmt:
+		if init, isAssign := stmt.Init.(*goast.AssignStmt); isAssign && init.Tok == gotoken.DEFINE {
+			add(init.Lhs...)
+		}
+	default:
+		return nil, false
+	}
+	return vars, true
+}
@@ -1,6 +1,7 @@
 package macro_for
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/BestFriendChris/go-ic/ic"
@@ -35,24 +36,24 @@ for _, v := range vals {◊
 			################################################################################
 			# tokens
 			################################################################################
-			   | TT                | S                          | E |
-			---+-------------------+----------------------------+---+
-			 1 | TT.CodeLocalBlock | "for _, v := range vals {" |   |
-			---+-------------------+----------------------------+---+
-			 2 | TT.NL             | "\n"                       |   |
-			---+-------------------+----------------------------+---+
-			 3 | TT.WS             | "\t"                       |   |
-			---+-------------------+----------------------------+---+
-			 4 | TT.Content        | "<span>"                   |   |
-			---+-------------------+----------------------------+---+
-			 5 | TT.CodeLocalExpr  | "v"                        |   |
-			---+-------------------+----------------------------+---+
-			 6 | TT.Content        | "</span>"                  |   |
-			---+-------------------+----------------------------+---+
-			 7 | TT.NL             | "\n"                       |   |
-			---+-------------------+----------------------------+---+
-			 8 | TT.CodeLocalBlock | "}"                        |   |
-			---+-------------------+----------------------------+---+
+			   | TT                | S                          | E              |
+			---+-------------------+----------------------------+----------------+
+			 1 | TT.CodeLocalBlock | "for _, v := range vals {" | [for.vars=[v]] |
+			---+-------------------+----------------------------+----------------+
+			 2 | TT.NL             | "\n"                       |                |
+			---+-------------------+----------------------------+----------------+
+			 3 | TT.WS             | "\t"                       |                |
+			---+-------------------+----------------------------+----------------+
+			 4 | TT.Content        | "<span>"                   |                |
+			---+-------------------+----------------------------+----------------+
+			 5 | TT.CodeLocalExpr  | "v"                        |                |
+			---+-------------------+----------------------------+----------------+
+			 6 | TT.Content        | "</span>"                  |                |
+			---+-------------------+----------------------------+----------------+
+			 7 | TT.NL             | "\n"                       |                |
+			---+-------------------+----------------------------+----------------+
+			 8 | TT.CodeLocalBlock | "}"                        |                |
+			---+-------------------+----------------------------+----------------+
 			################################################################################
 			# rest
 			################################################################################
@@ -116,16 +117,44 @@ for _, v := range vals {◊
 	})
 }
 
+func TestMacroFor_Vars(t *testing.T) {
+	tests := []struct {
+		head     string
+		wantVars []string
+		wantOk   bool
+	}{
+		{"for _, v := range vals {", []string{"v"}, true},
+		{"for i, v := range vals {", []string{"i", "v"}, true},
+		{"for i := 0; i < 10; i++ {", []string{"i"}, true},
+		{"for i = range vals {", nil, true},
+		{"for {", nil, true},
+		{"for _, v := range {", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.head, func(t *testing.T) {
+			ct := tokenizer.NewDefault(interfaces.NewMacros())
+			toks, err := New().NextTokens(ct, input.NewInput("test", tt.head+"◊◊}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotVars, gotOk := Vars.Get(toks[0])
+			if gotOk != tt.wantOk || !reflect.DeepEqual(gotVars, tt.wantVars) {
+				t.Errorf("got %v (found %t) want %v (found %t)", gotVars, gotOk, tt.wantVars, tt.wantOk)
+			}
+		})
+	}
+}
+
 func printTokensTable(c *ic.IC, tokens []*token.Token) {
 	c.PrintSection("tokens")
 	type tokensTable struct {
 		TT token.TokenType
 		S  string
-		E  *any
+		E  token.Attrs
 	}
 	tt := make([]tokensTable, len(tokens))
 	for i, toks := range tokens {
-		tt[i] = tokensTable{toks.TT, toks.Slc.S, toks.E}
+		tt[i] = tokensTable{toks.TT, toks.Slc.S, toks.Attrs()}
 	}
 	c.PT(tt)
 }
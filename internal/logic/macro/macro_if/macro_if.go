@@ -5,6 +5,7 @@ import (
 
 	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
@@ -15,6 +16,10 @@ func New() *MacroIf {
 type MacroIf struct {
 }
 
+// Branch holds which part of the if a code token is: "if", "else if",
+// "else" or "end"
+var Branch = token.NewKey[string]("if.branch")
+
 var (
 	elseIfRegex = regexp.MustCompile(`}\s*else\s*if\s`)
 	elseRegex   = regexp.MustCompile(`}\s*else\s*`)
@@ -32,6 +37,7 @@ func (m *MacroIf) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 	if err != nil {
 		return nil, err
 	}
+	Branch.Set(tok, "if")
 	tokens = append(tokens, tok)
 
 	var subTokens []*token.Token
@@ -52,6 +58,7 @@ func (m *MacroIf) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 		if err != nil {
 			return nil, err
 		}
+		Branch.Set(tok, "else if")
 		tokens = append(tokens, tok)
 
 		subTokens, err = ct.ReadTokensUntil(in, "◊}")
@@ -70,6 +77,7 @@ func (m *MacroIf) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 		if err != nil {
 			return nil, err
 		}
+		Branch.Set(tok, "else")
 		tokens = append(tokens, tok)
 
 		subTokens, err = ct.ReadTokensUntil(in, "◊}")
@@ -83,11 +91,20 @@ func (m *MacroIf) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (t
 	}
 
 	tok = token.NewToken(token.TTcodeLocalBlock, in.ShiftSlice('}'))
+	Branch.Set(tok, "end")
 	tokens = append(tokens, tok)
 
 	return tokens, nil
 }
 
+func (m *MacroIf) ParseNode(tp interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	branches, closeTok, rest, err := tp.ParseBlock(toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	return &ast.If{Macro: macro.Slc, Branches: branches, Close: closeTok.Slc}, rest, nil
+}
+
 func (m *MacroIf) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
 	return toks, nil
 }
@@ -35,20 +35,20 @@ if reflect.DeepEqual(val, []string{"foo"}) {◊
 			################################################################################
 			# tokens
 			################################################################################
-			   | TT                | S                                                | E |
-			---+-------------------+--------------------------------------------------+---+
-			 1 | TT.CodeLocalBlock | "if reflect.DeepEqual(val, []string{\"foo\"}) {" |   |
-			---+-------------------+--------------------------------------------------+---+
-			 2 | TT.NL             | "\n"                                             |   |
-			---+-------------------+--------------------------------------------------+---+
-			 3 | TT.WS             | "  "                                             |   |
-			---+-------------------+--------------------------------------------------+---+
-			 4 | TT.Content        | "hi"                                             |   |
-			---+-------------------+--------------------------------------------------+---+
-			 5 | TT.NL             | "\n"                                             |   |
-			---+-------------------+--------------------------------------------------+---+
-			 6 | TT.CodeLocalBlock | "}"                                              |   |
-			---+-------------------+--------------------------------------------------+---+
+			   | TT                | S                                                | E                 |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 1 | TT.CodeLocalBlock | "if reflect.DeepEqual(val, []string{\"foo\"}) {" | [if.branch="if"]  |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 2 | TT.NL             | "\n"                                             |                   |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 3 | TT.WS             | "  "                                             |                   |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 4 | TT.Content        | "hi"                                             |                   |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 5 | TT.NL             | "\n"                                             |                   |
+			---+-------------------+--------------------------------------------------+-------------------+
+			 6 | TT.CodeLocalBlock | "}"                                              | [if.branch="end"] |
+			---+-------------------+--------------------------------------------------+-------------------+
 			################################################################################
 			# rest
 			################################################################################
@@ -83,30 +83,30 @@ if true {◊
 			################################################################################
 			# tokens
 			################################################################################
-			   | TT                | S            | E |
-			---+-------------------+--------------+---+
-			 1 | TT.CodeLocalBlock | "if true {"  |   |
-			---+-------------------+--------------+---+
-			 2 | TT.NL             | "\n"         |   |
-			---+-------------------+--------------+---+
-			 3 | TT.WS             | "  "         |   |
-			---+-------------------+--------------+---+
-			 4 | TT.Content        | "foo"        |   |
-			---+-------------------+--------------+---+
-			 5 | TT.NL             | "\n"         |   |
-			---+-------------------+--------------+---+
-			 6 | TT.CodeLocalBlock | "}  else  {" |   |
-			---+-------------------+--------------+---+
-			 7 | TT.NL             | "\n"         |   |
-			---+-------------------+--------------+---+
-			 8 | TT.WS             | "  "         |   |
-			---+-------------------+--------------+---+
-			 9 | TT.Content        | "bar"        |   |
-			---+-------------------+--------------+---+
-			10 | TT.NL             | "\n"         |   |
-			---+-------------------+--------------+---+
-			11 | TT.CodeLocalBlock | "}"          |   |
-			---+-------------------+--------------+---+
+			   | TT                | S            | E                  |
+			---+-------------------+--------------+--------------------+
+			 1 | TT.CodeLocalBlock | "if true {"  | [if.branch="if"]   |
+			---+-------------------+--------------+--------------------+
+			 2 | TT.NL             | "\n"         |                    |
+			---+-------------------+--------------+--------------------+
+			 3 | TT.WS             | "  "         |                    |
+			---+-------------------+--------------+--------------------+
+			 4 | TT.Content        | "foo"        |                    |
+			---+-------------------+--------------+--------------------+
+			 5 | TT.NL             | "\n"         |                    |
+			---+-------------------+--------------+--------------------+
+			 6 | TT.CodeLocalBlock | "}  else  {" | [if.branch="else"] |
+			---+-------------------+--------------+--------------------+
+			 7 | TT.NL             | "\n"         |                    |
+			---+-------------------+--------------+--------------------+
+			 8 | TT.WS             | "  "         |                    |
+			---+-------------------+--------------+--------------------+
+			 9 | TT.Content        | "bar"        |                    |
+			---+-------------------+--------------+--------------------+
+			10 | TT.NL             | "\n"         |                    |
+			---+-------------------+--------------+--------------------+
+			11 | TT.CodeLocalBlock | "}"          | [if.branch="end"]  |
+			---+-------------------+--------------+--------------------+
 			################################################################################
 			# rest
 			################################################################################
@@ -146,50 +146,50 @@ if v == 1 {◊
 			################################################################################
 			# tokens
 			################################################################################
-			   | TT                | S                       | E |
-			---+-------------------+-------------------------+---+
-			 1 | TT.CodeLocalBlock | "if v == 1 {"           |   |
-			---+-------------------+-------------------------+---+
-			 2 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			 3 | TT.WS             | "  "                    |   |
-			---+-------------------+-------------------------+---+
-			 4 | TT.Content        | "one"                   |   |
-			---+-------------------+-------------------------+---+
-			 5 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			 6 | TT.CodeLocalBlock | "}  else  if v == 2 {"  |   |
-			---+-------------------+-------------------------+---+
-			 7 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			 8 | TT.WS             | "  "                    |   |
-			---+-------------------+-------------------------+---+
-			 9 | TT.Content        | "two"                   |   |
-			---+-------------------+-------------------------+---+
-			10 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			11 | TT.CodeLocalBlock | "}  else  if  v == 3 {" |   |
-			---+-------------------+-------------------------+---+
-			12 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			13 | TT.WS             | "  "                    |   |
-			---+-------------------+-------------------------+---+
-			14 | TT.Content        | "three"                 |   |
-			---+-------------------+-------------------------+---+
-			15 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			16 | TT.CodeLocalBlock | "}  else {"             |   |
-			---+-------------------+-------------------------+---+
-			17 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			18 | TT.WS             | "  "                    |   |
-			---+-------------------+-------------------------+---+
-			19 | TT.Content        | "four"                  |   |
-			---+-------------------+-------------------------+---+
-			20 | TT.NL             | "\n"                    |   |
-			---+-------------------+-------------------------+---+
-			21 | TT.CodeLocalBlock | "}"                     |   |
-			---+-------------------+-------------------------+---+
+			   | TT                | S                       | E                     |
+			---+-------------------+-------------------------+-----------------------+
+			 1 | TT.CodeLocalBlock | "if v == 1 {"           | [if.branch="if"]      |
+			---+-------------------+-------------------------+-----------------------+
+			 2 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 3 | TT.WS             | "  "                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 4 | TT.Content        | "one"                   |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 5 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 6 | TT.CodeLocalBlock | "}  else  if v == 2 {"  | [if.branch="else if"] |
+			---+-------------------+-------------------------+-----------------------+
+			 7 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 8 | TT.WS             | "  "                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			 9 | TT.Content        | "two"                   |                       |
+			---+-------------------+-------------------------+-----------------------+
+			10 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			11 | TT.CodeLocalBlock | "}  else  if  v == 3 {" | [if.branch="else if"] |
+			---+-------------------+-------------------------+-----------------------+
+			12 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			13 | TT.WS             | "  "                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			14 | TT.Content        | "three"                 |                       |
+			---+-------------------+-------------------------+-----------------------+
+			15 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			16 | TT.CodeLocalBlock | "}  else {"             | [if.branch="else"]    |
+			---+-------------------+-------------------------+-----------------------+
+			17 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			18 | TT.WS             | "  "                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			19 | TT.Content        | "four"                  |                       |
+			---+-------------------+-------------------------+-----------------------+
+			20 | TT.NL             | "\n"                    |                       |
+			---+-------------------+-------------------------+-----------------------+
+			21 | TT.CodeLocalBlock | "}"                     | [if.branch="end"]     |
+			---+-------------------+-------------------------+-----------------------+
 			################################################################################
 			# rest
 			################################################################################
@@ -315,11 +315,11 @@ func printTokensTable(c *ic.IC, tokens []*token.Token) {
 	type tokensTable struct {
 		TT token.TokenType
 		S  string
-		E  *any
+		E  token.Attrs
 	}
 	tt := make([]tokensTable, len(tokens))
 	for i, toks := range tokens {
-		tt[i] = tokensTable{toks.TT, toks.Slc.S, toks.E}
+		tt[i] = tokensTable{toks.TT, toks.Slc.S, toks.Attrs()}
 	}
 	c.PT(tt)
 }
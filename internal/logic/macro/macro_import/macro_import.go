@@ -0,0 +1,67 @@
+package macro_import
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+func New() *MacroImport {
+	return &MacroImport{}
+}
+
+// MacroImport requests an import from the handler:
+//
+//	◊.import "strings"
+//	◊.import str "strings"
+//	◊.import . "strings"
+type MacroImport struct{}
+
+var (
+	importRegex = regexp.MustCompile(`^import[ \t]+`)
+	eolRegex    = regexp.MustCompile(`^[ \t]*\n`)
+	specRegex   = regexp.MustCompile("^(?:(?:[\\pL_][\\pL\\pN_]*|\\.)[ \t]+)?(?:\"(?:[^\"\\\\\n]|\\\\.)*\"|`[^`\n]*`)")
+)
+
+// ttImport returns the token type holding a single import spec, e.g.
+// `"strings"` or `str "strings"`
+func ttImport(ns token.Namespace) token.TokenType {
+	return ns.Register("Import")
+}
+
+func (m *MacroImport) RegisterTokenTypes(ns token.Namespace) {
+	ttImport(ns)
+}
+
+func (m *MacroImport) Name() string {
+	return "import"
+}
+
+func (m *MacroImport) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	if _, found := in.ConsumeRegexp(importRegex); !found {
+		return nil, in.ErrorHere(fmt.Errorf("expected import path"))
+	}
+	spec, found := in.ConsumeRegexp(specRegex)
+	if !found {
+		return nil, in.ErrorHere(fmt.Errorf("expected import path"))
+	}
+	// Swallow the end of the directive's line so a block of imports doesn't
+	// leave blank lines behind in the output
+	_, _ = in.ConsumeRegexp(eolRegex)
+	return []*token.Token{token.NewToken(ttImport(ct.TokenTypes(m.Name())), spec)}, nil
+}
+
+func (m *MacroImport) Parse(h interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	tts, ok := h.(interfaces.TokenTypeSource)
+	if !ok {
+		return toks, fmt.Errorf("import: handler is unable to look up token types")
+	}
+	if len(toks) == 0 || toks[0].TT != ttImport(tts.TokenTypes(m.Name())) {
+		return toks, fmt.Errorf("import: expected import path")
+	}
+	h.WriteImport(toks[0].Slc)
+	return toks[1:], nil
+}
@@ -0,0 +1,224 @@
+package macro_import
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+func TestMacroImport_NextTokens(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", `import "strings"`+"\nbar")
+		m, r := newRegistered()
+		ct.SetTokenTypes(r)
+		tokens, err := m.NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokensTable(&c, r, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			   | TT               | S         |
+			---+------------------+-----------+
+			 1 | TT.import.Import | "strings" |
+			---+------------------+-----------+
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("aliased", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", `import str "strings"bar`)
+		m, r := newRegistered()
+		ct.SetTokenTypes(r)
+		tokens, err := m.NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokensTable(&c, r, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			   | TT               | S             |
+			---+------------------+---------------+
+			 1 | TT.import.Import | str "strings" |
+			---+------------------+---------------+
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("dot", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", `import . "strings"`+"  \nbar")
+		m, r := newRegistered()
+		ct.SetTokenTypes(r)
+		tokens, err := m.NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokensTable(&c, r, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			   | TT               | S           |
+			---+------------------+-------------+
+			 1 | TT.import.Import | . "strings" |
+			---+------------------+-------------+
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("blank", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", `import _ "embed"bar`)
+		m, r := newRegistered()
+		ct.SetTokenTypes(r)
+		tokens, err := m.NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokensTable(&c, r, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			   | TT               | S         |
+			---+------------------+-----------+
+			 1 | TT.import.Import | _ "embed" |
+			---+------------------+-----------+
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+}
+
+func TestMacroImport_NextTokens_errorCases(t *testing.T) {
+	t.Run("no import path", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "import strings\nbar")
+		m, _ := newRegistered()
+		_, err := m.NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			expected import path
+			  ┌─ test:1:8
+			  │
+			1 │ import strings
+			  │        ^
+			2 │ bar
+			`)
+	})
+}
+
+func TestMacroImport_Parse(t *testing.T) {
+	m, r := newRegistered()
+	in := input.NewInput("test", `str "strings"`)
+	toks := []*token.Token{
+		token.NewToken(r.Namespace(m.Name()).Register("Import"), in.SliceAt(0, 13)),
+		token.NewToken(token.TTcontent, in.SliceAt(0, 3)),
+	}
+	h := &importHandler{tokenTypes: r}
+	rest, err := m.Parse(h, toks)
+
+	c := ic.New(t)
+	c.PrintSection("imports")
+	c.Println(h.imports)
+	c.PrintSection("rest")
+	c.Println(rest, err)
+
+	c.Expect(`
+		################################################################################
+		# imports
+		################################################################################
+		[test:1 - "str \"strings\""]
+		################################################################################
+		# rest
+		################################################################################
+		[TT.Content("str")] <nil>
+		`)
+}
+
+// newRegistered returns a MacroImport along with the registry holding its
+// token types
+func newRegistered() (*MacroImport, *token.Registry) {
+	r := token.NewRegistry()
+	m := New()
+	m.RegisterTokenTypes(r.Namespace(m.Name()))
+	return m, r
+}
+
+func printTokensTable(c *ic.IC, r *token.Registry, tokens []*token.Token) {
+	c.PrintSection("tokens")
+	type tokensTable struct {
+		TT string
+		S  string
+	}
+	tt := make([]tokensTable, len(tokens))
+	for i, toks := range tokens {
+		tt[i] = tokensTable{r.Name(toks.TT), toks.Slc.S}
+	}
+	c.PT(tt)
+}
+
+type importHandler struct {
+	interfaces.TemplateHandler
+	tokenTypes *token.Registry
+	imports    []input.Slice
+}
+
+func (h *importHandler) TokenTypes(macro string) token.Namespace {
+	return h.tokenTypes.Namespace(macro)
+}
+
+func (h *importHandler) WriteImport(slc input.Slice) {
+	h.imports = append(h.imports, slc)
+}
@@ -0,0 +1,72 @@
+package macro_raw
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+func New() *MacroRaw {
+	return &MacroRaw{}
+}
+
+// MacroRaw writes out its body just as it is, markers and all:
+//
+//	◊.raw {◊ ◊name is left alone ◊}
+//
+// A body that needs to hold "◊}" is fenced with any number of "#", and ends
+// at "◊}" followed by as many:
+//
+//	◊.raw #{◊ ◊.for x {◊ ◊x ◊} ◊}#
+type MacroRaw struct{}
+
+var openRegex = regexp.MustCompile(`^raw[ \t]*(#*)\{◊`)
+
+func (m *MacroRaw) Name() string {
+	return "raw"
+}
+
+// NextTokens returns the body as TTcontent tokens, a line to each
+func (m *MacroRaw) NextTokens(_ interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	start := in.Pos().Idx
+	open, found := in.ConsumeRegexp(openRegex)
+	if !found {
+		in.ConsumeString(m.Name())
+		return nil, in.ErrorHere(fmt.Errorf(`expected "{◊" or "#{◊"`))
+	}
+	closeStr := "◊}" + strings.Repeat("#", strings.Count(open.S, "#"))
+
+	lineStart := in.Pos().Idx
+	addLine := func(end int) {
+		if end > lineStart {
+			toks = append(toks, token.NewToken(token.TTcontent, in.SliceAt(lineStart, end)))
+		}
+		lineStart = end
+	}
+	for !in.HasPrefix(closeStr) {
+		r, found := in.Peek()
+		if !found {
+			end := in.Pos().Idx
+			in.Seek(start)
+			return nil, in.ErrorAt(start, end, fmt.Errorf("did not find %q", closeStr),
+				input.Label{From: start, To: open.End.Idx, Msg: "opened here"},
+				input.Label{From: end, To: end, Msg: "input ended here"})
+		}
+		in.Shift(r)
+		if r == '\n' {
+			addLine(in.Pos().Idx)
+		}
+	}
+	addLine(in.Pos().Idx)
+	in.ConsumeString(closeStr)
+	return toks, nil
+}
+
+// Parse leaves the body's tokens to be written as the content they are
+func (m *MacroRaw) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	return toks, nil
+}
@@ -0,0 +1,172 @@
+package macro_raw
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+func TestMacroRaw_NextTokens(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw {◊ ◊name ◊{ x } ◊}bar")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			TT.Content(" ◊name ◊{ x } ")
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("multi line", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw {◊\n◊.if x {◊\n\t◊x\n◊}\n")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			TT.Content("\n")
+			TT.Content("◊.if x {◊\n")
+			TT.Content("\t◊x\n")
+			################################################################################
+			# rest
+			################################################################################
+			"\n"
+			`)
+	})
+	t.Run("fenced", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw ##{◊◊.for x {◊◊}#◊}##bar")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			TT.Content("◊.for x {◊◊}#")
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("empty", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw{◊◊}bar")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+}
+
+func TestMacroRaw_NextTokens_errorCases(t *testing.T) {
+	t.Run("no body", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw ◊name")
+		_, err := New().NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			expected "{◊" or "#{◊"
+			  ┌─ test:1:4
+			  │
+			1 │ raw ◊name
+			  │    ^
+			`)
+	})
+	t.Run("not closed", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "raw #{◊\n◊name ◊}\nbar")
+		_, err := New().NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			did not find "◊}#"
+			  ┌─ test:1:1
+			  │
+			1 │ raw #{◊
+			  │ ^^^^^^^
+			  │ ------- opened here
+			2 │ ◊name ◊}
+			3 │ bar
+			  │ ^^^
+			  │    - input ended here
+			`)
+	})
+}
+
+func printTokens(c *ic.IC, tokens []*token.Token) {
+	c.PrintSection("tokens")
+	for _, tok := range tokens {
+		c.Println(tok)
+	}
+}
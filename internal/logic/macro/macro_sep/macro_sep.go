@@ -0,0 +1,58 @@
+package macro_sep
+
+import (
+	"fmt"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+func New() *MacroSep {
+	return &MacroSep{}
+}
+
+// MacroSep separates the times round an ◊.for. Its body is written before
+// each but the first, wherever in the loop's body it's placed:
+//
+//	◊.for _, v := range vals {◊◊v◊.sep {◊, ◊}◊}
+//
+// It can only be used directly inside the body of an ◊.for.
+type MacroSep struct{}
+
+func (m MacroSep) Name() string {
+	return "sep"
+}
+
+func (m MacroSep) NextTokens(ct interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	ma, err := ct.ReadMacroArgs(in, m.Name())
+	if err != nil {
+		return nil, err
+	}
+	if ma.List.Len() > 0 {
+		return nil, in.ErrorAt(ma.List.Start.Idx, ma.List.End.Idx, fmt.Errorf("sep takes no arguments"))
+	}
+	if !ma.HasBody() {
+		return nil, in.ErrorAt(ma.Name.End.Idx, ma.Name.End.Idx, fmt.Errorf(`expected "{◊"`))
+	}
+	// The body is read as a block, like those of other block macros
+	toks = append(toks, token.NewToken(token.TTcodeLocalBlock, in.SliceAt(ma.Open.Start.Idx, ma.Open.Start.Idx+len("{"))))
+	toks = append(toks, ma.Body...)
+	return append(toks, token.NewToken(token.TTcodeLocalBlock, in.SliceAt(ma.Close.End.Idx-len("}"), ma.Close.End.Idx))), nil
+}
+
+func (m MacroSep) ParseNode(tp interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	branches, closeTok, rest, err := tp.ParseBlock(toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	if len(branches) > 1 {
+		return nil, toks, fmt.Errorf("sep: unexpected %q", branches[1].Head.S)
+	}
+	return &ast.Sep{Macro: macro.Slc, Body: branches[0].Body, Close: closeTok.Slc}, rest, nil
+}
+
+func (m MacroSep) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	return toks, nil
+}
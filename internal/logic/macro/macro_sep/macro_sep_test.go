@@ -0,0 +1,208 @@
+package macro_sep
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/parser"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+func TestMacroSep_NextTokens(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "sep {◊, ◊}bar")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			TT.CodeLocalBlock("{")
+			TT.Content(",")
+			TT.WS(" ")
+			TT.CodeLocalBlock("}")
+			################################################################################
+			# rest
+			################################################################################
+			"bar"
+			`)
+	})
+	t.Run("code in body", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "sep{◊◊{ n++ }◊sepChar◊}\nbar")
+		tokens, err := New().NextTokens(ct, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		printTokens(&c, tokens)
+
+		c.PrintSection("rest")
+		c.Printf("%q\n", in.Rest())
+		c.Expect(`
+			################################################################################
+			# tokens
+			################################################################################
+			TT.CodeLocalBlock("{")
+			TT.CodeLocalBlock(" n++ ")
+			TT.CodeLocalExpr("sepChar")
+			TT.CodeLocalBlock("}")
+			################################################################################
+			# rest
+			################################################################################
+			"\nbar"
+			`)
+	})
+}
+
+func TestMacroSep_NextTokens_errorCases(t *testing.T) {
+	t.Run("arguments", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "sep(1) {◊, ◊}")
+		_, err := New().NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			sep takes no arguments
+			  ┌─ test:1:4
+			  │
+			1 │ sep(1) {◊, ◊}
+			  │    ^^^
+			`)
+	})
+	t.Run("no body", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "sep ◊name")
+		_, err := New().NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			expected "{◊"
+			  ┌─ test:1:4
+			  │
+			1 │ sep ◊name
+			  │    ^
+			`)
+	})
+	t.Run("body not closed", func(t *testing.T) {
+		ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+		in := input.NewInput("test", "sep {◊, \nbar")
+		_, err := New().NextTokens(ct, in)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			did not find "◊}"
+			  ┌─ test:1:9
+			  │
+			1 │ sep {◊,
+			  │       ^^
+			2 │ bar
+			  │ ^^^
+			  │    - input ended here
+			`)
+	})
+}
+
+func TestMacroSep_ParseNode(t *testing.T) {
+	prs, toks := parse(t, "◊.sep {◊, ◊x◊}bar")
+	nodes, err := prs.ParseTree(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	var buf bytes.Buffer
+	_ = ast.Fprint(&buf, nodes)
+	c.Print(buf.String())
+	c.Expect(`
+		Sep
+		  Text ","
+		  Text " "
+		  Expr "x"
+		Text "bar"
+		`)
+}
+
+func TestMacroSep_Parse_errorCases(t *testing.T) {
+	t.Run("outside a for body", func(t *testing.T) {
+		prs, toks := parse(t, "a\n◊.sep {◊, ◊}")
+		_, err := prs.Parse(&main_handler.MainHandler{}, toks)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			◊.sep outside of a ◊.for body
+			  ┌─ test:2:5
+			  │
+			1 │ a
+			2 │ ◊.sep {◊, ◊}
+			  │   ^^^
+			`)
+	})
+}
+
+// parse reads the tokens of s, returning them along with a parser for them
+func parse(t *testing.T, s string) (*parser.DefaultParser, []*token.Token) {
+	t.Helper()
+	macros := interfaces.NewMacros()
+	macros.Add(New())
+	in := input.NewInput("test", s)
+	toks, err := tokenizer.NewDefault(macros).ReadAll(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prs := parser.New(macros)
+	prs.SetInput(in)
+	return prs, toks
+}
+
+func printTokens(c *ic.IC, tokens []*token.Token) {
+	c.PrintSection("tokens")
+	for _, tok := range tokens {
+		c.Println(tok)
+	}
+}
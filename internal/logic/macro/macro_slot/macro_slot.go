@@ -0,0 +1,33 @@
+package macro_slot
+
+import (
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+func New() *MacroSlot {
+	return &MacroSlot{}
+}
+
+// MacroSlot marks where a component defined by ◊.define writes the body it
+// was called with. It can only be used inside a component.
+type MacroSlot struct{}
+
+func (m MacroSlot) Name() string {
+	return "slot"
+}
+
+func (m MacroSlot) NextTokens(_ interfaces.ContentTokenizer, in *input.Input) (toks []*token.Token, err error) {
+	in.ConsumeString(m.Name())
+	return nil, nil
+}
+
+func (m MacroSlot) ParseNode(_ interfaces.TreeParser, macro *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	return &ast.Slot{Macro: macro.Slc}, toks, nil
+}
+
+func (m MacroSlot) Parse(_ interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
+	return toks, nil
+}
@@ -0,0 +1,150 @@
+package macro_slot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/handler/main_handler"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_define"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/parser"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+func TestMacroSlot_NextTokens(t *testing.T) {
+	ct := tokenizer.NewDefault(interfaces.NewMacros())
+
+	in := input.NewInput("test", "slot◊}bar")
+	tokens, err := New().NextTokens(ct, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	c.PrintSection("tokens")
+	for _, tok := range tokens {
+		c.Println(tok)
+	}
+
+	c.PrintSection("rest")
+	c.Printf("%q\n", in.Rest())
+	c.Expect(`
+		################################################################################
+		# tokens
+		################################################################################
+		################################################################################
+		# rest
+		################################################################################
+		"◊}bar"
+		`)
+}
+
+func TestMacroSlot_ParseNode(t *testing.T) {
+	prs, toks := parse(t, "◊.define card() {◊<b>◊.slot</b>◊}")
+	nodes, err := prs.ParseTree(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	var buf bytes.Buffer
+	_ = ast.Fprint(&buf, nodes)
+	c.Print(buf.String())
+	c.Expect(`
+		Component "card() {"
+		  Text "<b>"
+		  Slot
+		  Text "</b>"
+		`)
+}
+
+func TestMacroSlot_Parse(t *testing.T) {
+	prs, toks := parse(t, "◊.define card() {◊<b>◊.slot</b>◊}")
+	h := &main_handler.MainHandler{}
+	if _, err := prs.Parse(h, toks); err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	c.Println(strings.Join(h.InlineOutput, "\n"))
+	c.Expect(`
+		//line test:1
+		card := func(slot func()) {
+		//line test:1
+		buf.WriteString("<b>")
+		//line test:1
+		slot()
+		//line test:1
+		buf.WriteString("</b>")
+		//line test:1
+		}
+		//line test:1
+		_ = card
+		`)
+}
+
+func TestMacroSlot_Parse_errorCases(t *testing.T) {
+	t.Run("outside a component", func(t *testing.T) {
+		prs, toks := parse(t, "a\nb ◊.slot c")
+		_, err := prs.Parse(&main_handler.MainHandler{}, toks)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			◊.slot outside of a component
+			  ┌─ test:2:7
+			  │
+			1 │ a
+			2 │ b ◊.slot c
+			  │     ^^^^
+			`)
+	})
+	t.Run("in a call's body", func(t *testing.T) {
+		prs, toks := parse(t, "◊.define card() {◊◊.slot◊}\n◊.card() {◊◊.slot◊}")
+		_, err := prs.Parse(&main_handler.MainHandler{}, toks)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			◊.slot outside of a component
+			  ┌─ test:2:20
+			  │
+			1 │ ◊.define card() {◊◊.slot◊}
+			2 │ ◊.card() {◊◊.slot◊}
+			  │              ^^^^
+			`)
+	})
+}
+
+// parse reads the tokens of s, returning them along with a parser for them.
+// Both share the same macros, so components defined while reading can be
+// parsed.
+func parse(t *testing.T, s string) (*parser.DefaultParser, []*token.Token) {
+	t.Helper()
+	macros := interfaces.NewMacros()
+	macros.Add(New())
+	macros.Add(macro_define.New())
+	in := input.NewInput("test", s)
+	toks, err := tokenizer.NewDefault(macros).ReadAll(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prs := parser.New(macros)
+	prs.SetInput(in)
+	return prs, toks
+}
@@ -0,0 +1,371 @@
+package parser
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+)
+
+// Emit walks nodes, writing each of them to h
+func Emit(h interfaces.TemplateHandler, nodes []ast.Node) error {
+	return emit(h, nodes, scope{})
+}
+
+// scope is where the nodes being emitted are
+type scope struct {
+	// inBlock is set inside any Go block, where a component would be local
+	// to the block
+	inBlock bool
+	// inComponent is set inside a component, where an ◊.slot can be used
+	inComponent bool
+}
+
+// block returns sc for the body of a Go block
+func (sc scope) block() scope {
+	sc.inBlock = true
+	return sc
+}
+
+// reservedNames are the identifiers the generated code relies on, which a
+// component mustn't be named after
+var reservedNames = map[string]bool{
+	"buf":           true,
+	"fmt":           true,
+	"slot":          true,
+	"lozengeEmpty":  true,
+	"lozengeBodies": true,
+	"lozengeBody":   true,
+}
+
+// emit is Emit for nodes in sc
+func emit(h interfaces.TemplateHandler, nodes []ast.Node, sc scope) error {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *ast.Text:
+			h.WriteTextContent(n.Slc)
+		case *ast.Expr:
+			h.WriteCodeLocalExpression(n.Slc)
+		case *ast.Code:
+			h.WriteCodeLocalBlock(n.Slc)
+		case *ast.GlobalCode:
+			h.WriteCodeGlobalBlock(n.Slc)
+		case *ast.Import:
+			h.WriteImport(n.Slc)
+		case *ast.If:
+			for _, b := range n.Branches {
+				h.WriteCodeLocalBlock(b.Head)
+				if err := emit(h, b.Body, sc.block()); err != nil {
+					return err
+				}
+			}
+			h.WriteCodeLocalBlock(n.Close)
+		case *ast.For:
+			if n.Helper != "" || n.Else != nil || hasSep(n.Body) {
+				if err := emitFor(h, n, sc.block()); err != nil {
+					return err
+				}
+				continue
+			}
+			h.WriteCodeLocalBlock(n.Head)
+			if err := emit(h, n.Body, sc.block()); err != nil {
+				return err
+			}
+			h.WriteCodeLocalBlock(n.Close)
+		case *ast.Component:
+			// A component is a func writing to the same output as the rest of
+			// the template, given its body as the func slot. It can be called
+			// anywhere after its definition, so it can't be local to a block.
+			if sc.inBlock {
+				return errorAt(n.Head, "◊.define of %q inside a block; components are defined at the top of the template", n.Name)
+			}
+			if reservedNames[n.Name] {
+				return errorAt(n.Head, "component can't be named %q, as the generated code uses it", n.Name)
+			}
+			params := "slot func()"
+			if p := trimArgs(n.Params); p != "" {
+				params = p + ", " + params
+			}
+			h.WriteCodeLocalBlock(withCode(n.Head, fmt.Sprintf("%s := func(%s) {", n.Name, params)))
+			if err := emit(h, n.Body, scope{inBlock: true, inComponent: true}); err != nil {
+				return err
+			}
+			h.WriteCodeLocalBlock(n.Close)
+			// Components needn't be called
+			h.WriteCodeLocalBlock(withCode(n.Close, "_ = "+n.Name))
+		case *ast.Call:
+			args := trimArgs(n.Args.S[1 : len(n.Args.S)-1])
+			if args != "" {
+				args += ", "
+			}
+			call := input.NewSlice(n.Name.Name, n.Name.S+n.Args.S, n.Name.Start, n.Args.End)
+			if n.Close.Len() == 0 {
+				h.WriteCodeLocalBlock(withCode(call, fmt.Sprintf("%s(%sfunc() {})", n.Name.S, args)))
+				continue
+			}
+			h.WriteCodeLocalBlock(withCode(call, fmt.Sprintf("%s(%sfunc() {", n.Name.S, args)))
+			if err := emit(h, n.Body, sc.block()); err != nil {
+				return err
+			}
+			h.WriteCodeLocalBlock(withCode(n.Close, "})"))
+		case *ast.Slot:
+			if !sc.inComponent {
+				return errorAt(n.Macro, "◊.slot outside of a component")
+			}
+			h.WriteCodeLocalBlock(withCode(n.Macro, "slot()"))
+		case *ast.Sep:
+			return errorAt(n.Macro, "◊.sep outside of a ◊.for body")
+		case *ast.Macro:
+			if err := emit(h, n.Body, sc); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("parser: unable to emit node %T", n)
+		}
+	}
+	return nil
+}
+
+// emitFor writes a loop with a helper, an else branch or an ◊.sep. It's
+// wrapped in a block of its own, holding the helper and whether the body has
+// run yet.
+//
+// A helper's Len and Last can't be known until the loop has finished, as
+// channels and iterators can't be counted up front, so when the body uses
+// them each time round is saved as a func and they're all run afterwards.
+func emitFor(h interfaces.TemplateHandler, n *ast.For, sc scope) error {
+	var seps, body []ast.Node
+	for _, child := range n.Body {
+		if _, isSep := child.(*ast.Sep); isSep {
+			seps = append(seps, child)
+		} else {
+			body = append(body, child)
+		}
+	}
+	var deferred bool
+	if n.Helper != "" {
+		var err error
+		if deferred, err = needsCount(n, seps, body, sc); err != nil {
+			return err
+		}
+	}
+	h.WriteCodeLocalBlock(withCode(n.Macro, "{"))
+	if n.Else != nil || len(seps) > 0 {
+		h.WriteCodeLocalBlock(withCode(n.Macro, "lozengeEmpty := true"))
+	}
+	if n.Helper != "" {
+		h.WriteCodeLocalBlock(withCode(n.Macro, n.Helper+" := struct{ Index, Len int; First, Last, Even, Odd bool }{Index: -1}"))
+	}
+	if deferred {
+		h.WriteCodeLocalBlock(withCode(n.Macro, "var lozengeBodies []func()"))
+	}
+	h.WriteCodeLocalBlock(n.Head)
+	if deferred {
+		// Each func keeps the loop's variables as they were that time round
+		if len(n.Vars) > 0 {
+			vars := strings.Join(n.Vars, ", ")
+			h.WriteCodeLocalBlock(withCode(n.Head, vars+" := "+vars))
+		}
+		h.WriteCodeLocalBlock(withCode(n.Head, "lozengeBodies = append(lozengeBodies, func() {"))
+	}
+	// Separators are written before the body, unless it's the first time
+	// round, so they needn't know which time is the last
+	for _, sep := range seps {
+		sep := sep.(*ast.Sep)
+		h.WriteCodeLocalBlock(withCode(sep.Macro, "if !lozengeEmpty {"))
+		if err := emit(h, sep.Body, sc); err != nil {
+			return err
+		}
+		h.WriteCodeLocalBlock(withCode(sep.Close, "}"))
+	}
+	if n.Else != nil || len(seps) > 0 {
+		h.WriteCodeLocalBlock(withCode(n.Head, "lozengeEmpty = false"))
+	}
+	if n.Helper != "" {
+		h.WriteCodeLocalBlock(withCode(n.Head, n.Helper+".Index++"))
+		if deferred {
+			h.WriteCodeLocalBlock(withCode(n.Head, fmt.Sprintf("%[1]s.First, %[1]s.Last = %[1]s.Index == 0, %[1]s.Index == %[1]s.Len-1", n.Helper)))
+		} else {
+			h.WriteCodeLocalBlock(withCode(n.Head, fmt.Sprintf("%[1]s.First = %[1]s.Index == 0", n.Helper)))
+		}
+		h.WriteCodeLocalBlock(withCode(n.Head, fmt.Sprintf("%[1]s.Even, %[1]s.Odd = %[1]s.Index%%2 == 0, %[1]s.Index%%2 == 1", n.Helper)))
+	}
+	if err := emit(h, body, sc); err != nil {
+		return err
+	}
+	// Where the loop's body ends
+	bodyEnd := n.Close
+	if n.Else != nil {
+		bodyEnd = n.Else.Head
+	}
+	if deferred {
+		h.WriteCodeLocalBlock(withCode(bodyEnd, "})"))
+		h.WriteCodeLocalBlock(withCode(bodyEnd, "}"))
+		h.WriteCodeLocalBlock(withCode(bodyEnd, n.Helper+".Len = len(lozengeBodies)"))
+		h.WriteCodeLocalBlock(withCode(bodyEnd, "for _, lozengeBody := range lozengeBodies {"))
+		h.WriteCodeLocalBlock(withCode(bodyEnd, "lozengeBody()"))
+	}
+	if n.Else != nil {
+		h.WriteCodeLocalBlock(withCode(n.Else.Head, "}"))
+		h.WriteCodeLocalBlock(withCode(n.Else.Head, "if lozengeEmpty {"))
+		if err := emit(h, n.Else.Body, sc); err != nil {
+			return err
+		}
+	}
+	h.WriteCodeLocalBlock(n.Close)
+	h.WriteCodeLocalBlock(withCode(n.Close, "}"))
+	return nil
+}
+
+// needsCount returns whether the body of n, a loop with a helper, uses the
+// helper's Len or Last, or the helper as a whole. As the body is then run
+// from a func, it's an error for it to break out of the loop, continue it or
+// return, or for the loop to assign to variables declared outside it, which
+// the funcs would share.
+func needsCount(n *ast.For, seps, body []ast.Node, sc scope) (bool, error) {
+	code := &codeRecorder{}
+	for _, sep := range seps {
+		if err := emit(code, sep.(*ast.Sep).Body, sc); err != nil {
+			return false, err
+		}
+	}
+	if err := emit(code, body, sc); err != nil {
+		return false, err
+	}
+	const prefix = "package p\nfunc _() {\n"
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "", prefix+n.Head.S+"\n"+code.String()+"\n}\n}\n", 0)
+	if err != nil {
+		// Code that doesn't parse won't build anyway
+		return true, nil
+	}
+	loop := f.Decls[0].(*goast.FuncDecl).Body.List[0].(*goast.RangeStmt)
+
+	var counted bool
+	var escape goast.Stmt
+	var stack []goast.Node
+	// Fields and methods named after the helper aren't uses of it
+	fields := make(map[*goast.Ident]bool)
+	goast.Inspect(loop.Body, func(node goast.Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		switch node := node.(type) {
+		case *goast.FuncLit:
+			// Its returns are its own
+			return false
+		case *goast.SelectorExpr:
+			if id, isIdent := node.X.(*goast.Ident); isIdent && id.Name == n.Helper {
+				switch node.Sel.Name {
+				case "Index", "First", "Even", "Odd":
+				default:
+					counted = true
+				}
+				return false
+			}
+			fields[node.Sel] = true
+		case *goast.Ident:
+			if node.Name == n.Helper && !fields[node] {
+				counted = true
+			}
+		case *goast.ReturnStmt:
+			escape = firstStmt(escape, node)
+		case *goast.BranchStmt:
+			if node.Label != nil || node.Tok == gotoken.GOTO || !inLoop(stack, node.Tok == gotoken.BREAK) {
+				escape = firstStmt(escape, node)
+			}
+		}
+		stack = append(stack, node)
+		return true
+	})
+	if !counted {
+		return false, nil
+	}
+	if escape != nil {
+		return false, errorAt(n.Head, "loop helper %s's Len and Last can't be used in a loop whose body uses %q", n.Helper, stmtKeyword(escape))
+	}
+	if loop.Tok == gotoken.ASSIGN {
+		return false, errorAt(n.Head, "loop helper %s's Len and Last need the loop's variables declared with :=", n.Helper)
+	}
+	return true, nil
+}
+
+// inLoop returns whether stack, the statements around a break or continue,
+// holds the loop it's for, or for a break a switch or select too
+func inLoop(stack []goast.Node, isBreak bool) bool {
+	for _, node := range stack {
+		switch node.(type) {
+		case *goast.ForStmt, *goast.RangeStmt:
+			return true
+		case *goast.SwitchStmt, *goast.TypeSwitchStmt, *goast.SelectStmt:
+			if isBreak {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func firstStmt(found, stmt goast.Stmt) goast.Stmt {
+	if found != nil {
+		return found
+	}
+	return stmt
+}
+
+func stmtKeyword(stmt goast.Stmt) string {
+	if b, isBranch := stmt.(*goast.BranchStmt); isBranch {
+		return b.Tok.String()
+	}
+	return "return"
+}
+
+// codeRecorder is a TemplateHandler keeping just the Go code run in place, so
+// it can be parsed
+type codeRecorder struct {
+	interfaces.TemplateHandler
+	sb strings.Builder
+}
+
+func (r *codeRecorder) WriteTextContent(input.Slice)     {}
+func (r *codeRecorder) WriteCodeGlobalBlock(input.Slice) {}
+func (r *codeRecorder) WriteImport(input.Slice)          {}
+
+func (r *codeRecorder) WriteCodeLocalExpression(slc input.Slice) {
+	r.sb.WriteString("_ = " + slc.S + "\n")
+}
+
+func (r *codeRecorder) WriteCodeLocalBlock(slc input.Slice) {
+	r.sb.WriteString(slc.S + "\n")
+}
+
+func (r *codeRecorder) String() string {
+	return r.sb.String()
+}
+
+// hasSep returns whether any of nodes is an ◊.sep
+func hasSep(nodes []ast.Node) bool {
+	for _, n := range nodes {
+		if _, isSep := n.(*ast.Sep); isSep {
+			return true
+		}
+	}
+	return false
+}
+
+// withCode returns slc holding code in place of its template text, for Go
+// code generated from it
+func withCode(slc input.Slice, code string) input.Slice {
+	return input.NewSlice(slc.Name, code, slc.Start, slc.End)
+}
+
+// trimArgs trims the space and any trailing comma from a list of parameters
+// or arguments, so more can be added to its end
+func trimArgs(list string) string {
+	return strings.TrimSuffix(strings.TrimSpace(list), ",")
+}
@@ -2,8 +2,15 @@ package parser
 
 import (
 	"fmt"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"regexp"
+	"strings"
 
+	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
@@ -14,38 +21,212 @@ func New(macros *interfaces.Macros) *DefaultParser {
 }
 
 type DefaultParser struct {
-	macros *interfaces.Macros
+	macros     *interfaces.Macros
+	tokenTypes *token.Registry
+	in         *input.Input
 }
 
+// SetInput sets the input the tokens were read from, so errors can point at
+// the template text they're about
+func (p *DefaultParser) SetInput(in *input.Input) {
+	p.in = in
+}
+
+// SetTokenTypes sets the registry macros look up their token types in. Until
+// it's set, the parser uses one of its own.
+func (p *DefaultParser) SetTokenTypes(r *token.Registry) {
+	p.tokenTypes = r
+}
+
+var elseRegex = regexp.MustCompile(`^}\s*else\b`)
+
 func (p *DefaultParser) Parse(h interfaces.TemplateHandler, toks []*token.Token) (rest []*token.Token, err error) {
-	var idx int
-	for i, tok := range toks {
-		idx = i
+	nodes, err := p.ParseTree(toks)
+	if err != nil {
+		return toks, err
+	}
+	err = Emit(h, nodes)
+	if err != nil {
+		return toks, p.sourceError(err)
+	}
+	return toks[len(toks):], nil
+}
+
+func (p *DefaultParser) ParseTree(toks []*token.Token) ([]ast.Node, error) {
+	nodes, rest, err := p.parseNodes(toks, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("parser: unexpected token %s", rest[0])
+	}
+	return nodes, nil
+}
+
+func (p *DefaultParser) ParseBlock(toks []*token.Token) (branches []*ast.Branch, closeTok *token.Token, rest []*token.Token, err error) {
+	if len(toks) == 0 || toks[0].TT != token.TTcodeLocalBlock || !strings.HasSuffix(strings.TrimSpace(toks[0].Slc.S), "{") {
+		return nil, nil, toks, fmt.Errorf("parser: expected block opening with '{'")
+	}
+	head := toks[0]
+	rest = toks[1:]
+	for {
+		var body []ast.Node
+		body, rest, err = p.parseNodes(rest, true)
+		if err != nil {
+			return nil, nil, toks, err
+		}
+		branches = append(branches, &ast.Branch{Head: head.Slc, Body: body})
+		if len(rest) == 0 {
+			return nil, nil, toks, fmt.Errorf("parser: block %s is never closed", toks[0])
+		}
+		delim := rest[0]
+		rest = rest[1:]
+		code := strings.TrimSpace(delim.Slc.S)
+		switch {
+		case code == "}":
+			return branches, delim, rest, nil
+		case elseRegex.MatchString(code) && strings.HasSuffix(code, "{"):
+			head = delim
+		default:
+			return nil, nil, toks, fmt.Errorf("parser: unexpected %s in block %s", delim, toks[0])
+		}
+	}
+}
+
+// parseNodes reads nodes until the tokens run out. When inBlock is set it
+// also stops at a code token closing the enclosing block, leaving that token
+// at the start of rest.
+func (p *DefaultParser) parseNodes(toks []*token.Token, inBlock bool) (nodes []ast.Node, rest []*token.Token, err error) {
+	// Braces opened by code inside this block (e.g. a multi-line ◊{ })
+	// must be closed before a `}` can end the block itself
+	var depth int
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
 		switch tok.TT {
 		case token.TTcontent, token.TTnl, token.TTws:
-			h.WriteTextContent(tok.Slc)
+			nodes = append(nodes, &ast.Text{Slc: tok.Slc})
 		case token.TTcodeGlobalBlock:
-			h.WriteCodeGlobalBlock(tok.Slc)
+			nodes = append(nodes, &ast.GlobalCode{Slc: tok.Slc})
 		case token.TTcodeLocalBlock:
-			h.WriteCodeLocalBlock(tok.Slc)
+			if inBlock && depth == 0 && strings.HasPrefix(strings.TrimSpace(tok.Slc.S), "}") {
+				return nodes, toks[i:], nil
+			}
+			depth += braceDepth(tok.Slc.S)
+			nodes = append(nodes, &ast.Code{Slc: tok.Slc})
 		case token.TTcodeLocalExpr:
-			h.WriteCodeLocalExpression(tok.Slc)
+			nodes = append(nodes, &ast.Expr{Slc: tok.Slc})
 		case token.TTmacro:
 			if p.macros == nil {
-				return toks, fmt.Errorf("parser: unknown macro %q", tok.Slc.S)
+				return nil, toks, p.unknownMacro(tok.Slc)
 			}
 			m, found := p.macros.Get(tok.Slc.S)
-			if found {
-				rest, err := m.Parse(h, toks)
-				if err != nil {
-					return rest, err
-				}
-			} else {
-				return toks, fmt.Errorf("parser: unknown macro %q", tok.Slc.S)
+			if !found {
+				return nil, toks, p.unknownMacro(tok.Slc)
+			}
+			var n ast.Node
+			var macroRest []*token.Token
+			n, macroRest, err = p.parseMacro(m, tok, toks[i+1:])
+			if err != nil {
+				return nil, toks, err
 			}
+			nodes = append(nodes, n)
+			i = len(toks) - len(macroRest) - 1
 		default:
-			return toks[i:], fmt.Errorf("parser: unrecognized token type %q: %s", tok.TT, tok)
+			return nil, toks[i:], fmt.Errorf("parser: unrecognized token type %q: %s", tok.TT, tok)
+		}
+	}
+	return nodes, toks[len(toks):], nil
+}
+
+// unknownMacro returns the error for slc naming a macro the parser doesn't
+// know, suggesting known ones it's close to when the input is set
+func (p *DefaultParser) unknownMacro(slc input.Slice) error {
+	if p.in == nil {
+		return fmt.Errorf("parser: unknown macro %q", slc.S)
+	}
+	var known []string
+	if p.macros != nil {
+		known = p.macros.Known()
+	}
+	return p.in.UnknownError("macro", slc, known)
+}
+
+// nodeError is an error in the template text slc
+type nodeError struct {
+	slc input.Slice
+	err error
+}
+
+func errorAt(slc input.Slice, format string, args ...any) error {
+	return &nodeError{slc: slc, err: fmt.Errorf(format, args...)}
+}
+
+func (e *nodeError) Error() string {
+	pos := e.slc.Start
+	return fmt.Sprintf("parser: %s:%d:%d: %v", e.slc.Name, pos.Row, pos.Col, e.err)
+}
+
+func (e *nodeError) Unwrap() error {
+	return e.err
+}
+
+// sourceError returns err pointing at the template text it's about when it's
+// a nodeError and the input is set, so it's shown like the tokenizer's errors
+func (p *DefaultParser) sourceError(err error) error {
+	ne, ok := err.(*nodeError)
+	if !ok || p.in == nil {
+		return err
+	}
+	return p.in.ErrorAt(ne.slc.Start.Idx, ne.slc.End.Idx, ne.err)
+}
+
+func (p *DefaultParser) parseMacro(m interfaces.Macro, tok *token.Token, toks []*token.Token) (n ast.Node, rest []*token.Token, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			n, rest = nil, toks
+			err = p.sourceError(&nodeError{slc: tok.Slc, err: &errors.MacroPanic{Macro: tok.Slc.S, Value: r}})
+		}
+	}()
+	if np, ok := m.(interfaces.NodeParser); ok {
+		return np.ParseNode(p, tok, toks)
+	}
+	// Anything else may write to the handler itself, so capture what it
+	// writes as the macro's body
+	rec := &recorder{p: p}
+	rest, err = m.Parse(rec, toks)
+	if err != nil {
+		return nil, toks, err
+	}
+	if !isSuffix(toks, rest) {
+		return nil, toks, fmt.Errorf("parser: macro %q returned tokens it wasn't given", tok.Slc.S)
+	}
+	return &ast.Macro{Name: tok.Slc, Body: rec.nodes}, rest, nil
+}
+
+func isSuffix(toks, rest []*token.Token) bool {
+	if len(rest) > len(toks) {
+		return false
+	}
+	return len(rest) == 0 || toks[len(toks)-len(rest)] == rest[0]
+}
+
+// braceDepth returns how many more braces code opens than it closes,
+// ignoring any in strings or comments
+func braceDepth(code string) int {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s goscanner.Scanner
+	s.Init(file, []byte(code), nil, goscanner.ScanComments)
+	var depth int
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case gotoken.EOF:
+			return depth
+		case gotoken.LBRACE:
+			depth++
+		case gotoken.RBRACE:
+			depth--
 		}
 	}
-	return toks[idx:], nil
 }
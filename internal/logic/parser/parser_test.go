@@ -8,8 +8,13 @@ import (
 	"github.com/BestFriendChris/go-ic/ic"
 	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_for"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_if"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_import"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
 )
 
 func TestParser_Parse(t *testing.T) {
@@ -99,6 +104,205 @@ val
 	})
 }
 
+func TestParser_ParseTree(t *testing.T) {
+	t.Run("nested macros", func(t *testing.T) {
+		nodes, err := parseTree(t, `
+◊.import "strings"
+◊.for _, v := range vals {◊
+	◊.if v == "a" {◊
+		◊{
+			if true {
+				x := 1
+			}
+		}
+		A ◊v
+	◊} else if v == "b" {◊
+		B
+	◊} else {◊
+		◊(strings.ToUpper(v))
+	◊}
+◊}`[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		c.PrintSection("tree")
+		_ = ast.Fprint(&c.Writer, nodes)
+		c.Expect(`
+			################################################################################
+			# tree
+			################################################################################
+			Macro import
+			  Import "strings"
+			For "for _, v := range vals {"
+			  If
+			    Branch "if v == \"a\" {"
+			      Code "\t\t\tif true {"
+			      Code "\t\t\t\tx := 1"
+			      Code "\t\t\t}"
+			      Text "\t\tA "
+			      Expr "v"
+			      Text "\n"
+			    Branch "} else if v == \"b\" {"
+			      Text "\t\tB\n"
+			    Branch "} else {"
+			      Text "\t\t"
+			      Expr "(strings.ToUpper(v))"
+			      Text "\n"
+			`)
+	})
+	t.Run("positions", func(t *testing.T) {
+		nodes, err := parseTree(t, `
+foo
+◊.if true {◊
+bar
+◊}`[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := ic.New(t)
+		ast.Inspect(nodes, func(n ast.Node) bool {
+			c.Printf("%T %s - %s\n", n, n.Start(), n.End())
+			return true
+		})
+		c.Expect(`
+			*ast.Text Pos[line=1;col=1] - Pos[line=2;col=1]
+			*ast.If Pos[line=2;col=5] - Pos[line=4;col=5]
+			*ast.Branch Pos[line=2;col=5] - Pos[line=4;col=1]
+			*ast.Text Pos[line=3;col=1] - Pos[line=4;col=1]
+			`)
+	})
+}
+
+func TestParser_ParseTree_errorCases(t *testing.T) {
+	t.Run("else if in for", func(t *testing.T) {
+		in := input.NewInput("test", `for v := range vals {} else if x {}`)
+		toks := []*token.Token{
+			token.NewToken(token.TTmacro, in.SliceAt(0, 3)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(0, 21)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(21, 34)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(34, 35)),
+		}
+		macros := interfaces.NewMacros()
+		macros.Add(macro_for.New())
+		_, err := New(macros).ParseTree(toks)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			for: unexpected "} else if x {"
+			`)
+	})
+	t.Run("unclosed block", func(t *testing.T) {
+		in := input.NewInput("test", `if v {`)
+		toks := []*token.Token{
+			token.NewToken(token.TTmacro, in.SliceAt(0, 2)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(0, 6)),
+		}
+		macros := interfaces.NewMacros()
+		macros.Add(macro_if.New())
+		_, err := New(macros).ParseTree(toks)
+
+		c := ic.New(t)
+		c.PrintSection("error")
+		c.Println(err)
+		c.Expect(`
+			################################################################################
+			# error
+			################################################################################
+			parser: block TT.CodeLocalBlock("if v {") is never closed
+			`)
+	})
+	t.Run("unknown macro", func(t *testing.T) {
+		in := input.NewInput("test", `◊.fro v := range vals {}`)
+		in.SetErrorRenderer(errors.CompactRenderer{})
+		toks := []*token.Token{
+			token.NewToken(token.TTmacro, in.SliceAt(4, 7)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(8, 25)),
+			token.NewToken(token.TTcodeLocalBlock, in.SliceAt(25, 26)),
+		}
+		macros := interfaces.NewMacros()
+		macros.Add(macro_for.New())
+		macros.Add(macro_if.New())
+
+		c := ic.New(t)
+		_, err := New(macros).ParseTree(toks)
+		c.Println(err)
+		p := New(macros)
+		p.SetInput(in)
+		_, err = p.ParseTree(toks)
+		c.Println(err)
+		te, _ := errors.Find(err)
+		c.Println(te.Suggestions)
+		c.Expect(`
+			parser: unknown macro "fro"
+			test:1:5: unknown macro "fro"; did you mean "for"?
+			[{4 7 for}]
+			`)
+	})
+}
+
+func TestParser_ParseTree_macroRest(t *testing.T) {
+	in := input.NewInput("test", `◊.foreign bar`)
+	toks := []*token.Token{
+		token.NewToken(token.TTmacro, in.SliceAt(4, 11)),
+		token.NewToken(token.TTcontent, in.SliceAt(11, 15)),
+	}
+	macros := interfaces.NewMacros()
+	macros.Add(foreignRestMacro{})
+	_, err := New(macros).ParseTree(toks)
+
+	c := ic.New(t)
+	c.PrintSection("error")
+	c.Println(err)
+	c.Expect(`
+		################################################################################
+		# error
+		################################################################################
+		parser: macro "foreign" returned tokens it wasn't given
+		`)
+}
+
+func parseTree(t *testing.T, s string) ([]ast.Node, error) {
+	t.Helper()
+	macros := interfaces.NewMacros()
+	macros.Add(macro_if.New())
+	macros.Add(macro_for.New())
+	macros.Add(macro_import.New())
+
+	toks, err := tokenizer.NewDefault(macros).ReadAll(input.NewInput("test", s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks = tokenizer.Optimize(toks, true)
+	return New(macros).ParseTree(toks)
+}
+
+// foreignRestMacro hands back copies of the tokens it was given
+type foreignRestMacro struct{}
+
+func (m foreignRestMacro) Name() string {
+	return "foreign"
+}
+
+func (m foreignRestMacro) NextTokens(_ interfaces.ContentTokenizer, _ *input.Input) ([]*token.Token, error) {
+	return nil, nil
+}
+
+func (m foreignRestMacro) Parse(_ interfaces.TemplateHandler, toks []*token.Token) ([]*token.Token, error) {
+	var rest []*token.Token
+	for _, tok := range toks {
+		rest = append(rest, token.NewToken(tok.TT, tok.Slc))
+	}
+	return rest, nil
+}
+
 type testHandler struct {
 	GlobalOutput, LocalOutput strings.Builder
 }
@@ -125,6 +329,11 @@ func (h *testHandler) WriteCodeGlobalBlock(slc input.Slice) {
 	_, _ = fmt.Fprintln(&h.GlobalOutput, s)
 }
 
+func (h *testHandler) WriteImport(slc input.Slice) {
+	s := fmt.Sprintf("%s import %s", line(slc), slc.S)
+	_, _ = fmt.Fprintln(&h.GlobalOutput, s)
+}
+
 func (h *testHandler) Done() (string, error) {
 	var sb strings.Builder
 	_, _ = fmt.Fprintln(&sb, "################################################################################")
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+// recorder is a TemplateHandler that turns everything written to it into
+// nodes
+type recorder struct {
+	p     *DefaultParser
+	nodes []ast.Node
+}
+
+func (r *recorder) ParseBody(toks []*token.Token) error {
+	nodes, err := r.p.ParseTree(toks)
+	if err != nil {
+		return err
+	}
+	r.nodes = append(r.nodes, nodes...)
+	return nil
+}
+
+func (r *recorder) TokenTypes(macro string) token.Namespace {
+	if r.p.tokenTypes == nil {
+		r.p.tokenTypes = token.NewRegistry()
+	}
+	return r.p.tokenTypes.Namespace(macro)
+}
+
+func (r *recorder) DefaultMacros() *interfaces.Macros {
+	return nil
+}
+
+func (r *recorder) WriteTextContent(slc input.Slice) {
+	r.nodes = append(r.nodes, &ast.Text{Slc: slc})
+}
+
+func (r *recorder) WriteCodeGlobalBlock(slc input.Slice) {
+	r.nodes = append(r.nodes, &ast.GlobalCode{Slc: slc})
+}
+
+func (r *recorder) WriteCodeLocalExpression(slc input.Slice) {
+	r.nodes = append(r.nodes, &ast.Expr{Slc: slc})
+}
+
+func (r *recorder) WriteCodeLocalBlock(slc input.Slice) {
+	r.nodes = append(r.nodes, &ast.Code{Slc: slc})
+}
+
+func (r *recorder) WriteImport(slc input.Slice) {
+	r.nodes = append(r.nodes, &ast.Import{Slc: slc})
+}
+
+func (r *recorder) Done() (string, error) {
+	return "", nil
+}
@@ -0,0 +1,185 @@
+// Package sourcemap maps ranges of generated Go code back to the slices of
+// the template they were generated from.
+package sourcemap
+
+import (
+	"encoding/json"
+	"fmt"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"io"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/column"
+)
+
+// Mapping ties the code between GoStart and GoEnd in the generated Go to the
+// template slice Src
+type Mapping struct {
+	GoStart, GoEnd input.Pos
+	Src            input.Slice
+}
+
+// SourceMap holds a Mapping for each segment of generated code, in the order
+// they appear in the generated Go. File is the name of the generated Go file,
+// if known.
+type SourceMap struct {
+	File     string
+	Mappings []Mapping
+}
+
+// Build maps the segments a handler generated onto goCode, the handler's
+// output after formatting. Each segment is found by its //line comment, so
+// the code may have been reflowed.
+func Build(goCode string, segs []interfaces.Segment) (*SourceMap, error) {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(goCode))
+	toks, comments := scan(file, goCode)
+	// The code of a segment may hold //line comments of its own, so the
+	// handler's comment for each segment is found by counting those
+	var (
+		ownComment = make([]int, len(segs))
+		segToks    = make([]int, len(segs))
+		want       int
+	)
+	for i, seg := range segs {
+		ownComment[i] = want
+		var n int
+		segToks[i], n = countTokens(seg.Code)
+		want += 1 + n
+	}
+	if len(comments) != want {
+		return nil, fmt.Errorf("sourcemap: found %d //line comments, want %d for %d segments", len(comments), want, len(segs))
+	}
+	pos := func(offset int) input.Pos {
+		// Ignore the //line comments, which would give template positions
+		p := file.PositionFor(file.Pos(offset), false)
+		lineStart := offset - (p.Column - 1)
+		runeCol, displayCol := column.Of(goCode[lineStart:], p.Column-1, column.DefaultTabWidth)
+		return input.Pos{Idx: offset, Row: p.Line, Col: p.Column, RuneCol: runeCol, DisplayCol: displayCol}
+	}
+	sm := &SourceMap{}
+	for i, seg := range segs {
+		// The segment's code runs from the token after its comment to either
+		// the end of its own tokens or the next comment, whichever is first
+		first := comments[ownComment[i]]
+		following := toks[first:]
+		if i+1 < len(segs) {
+			following = toks[first:comments[ownComment[i+1]]]
+		}
+		n := segToks[i]
+		if n > len(following) {
+			n = len(following)
+		}
+		m := Mapping{Src: seg.Src}
+		switch {
+		case n > 0:
+			m.GoStart = pos(following[0].start)
+			m.GoEnd = pos(following[n-1].end)
+		case first > 0:
+			m.GoStart = pos(toks[first-1].end)
+			m.GoEnd = m.GoStart
+		}
+		sm.Mappings = append(sm.Mappings, m)
+	}
+	return sm, nil
+}
+
+// Lookup returns the mapping containing the byte offset into the generated Go
+func (sm *SourceMap) Lookup(offset int) (Mapping, bool) {
+	for _, m := range sm.Mappings {
+		if m.GoStart.Idx <= offset && offset < m.GoEnd.Idx {
+			return m, true
+		}
+	}
+	return Mapping{}, false
+}
+
+type jsonMapping struct {
+	Go       jsonRange `json:"go"`
+	Template jsonRange `json:"template"`
+}
+
+type jsonRange struct {
+	Name  string    `json:"name,omitempty"`
+	Start input.Pos `json:"start"`
+	End   input.Pos `json:"end"`
+}
+
+type jsonSourceMap struct {
+	File     string        `json:"file,omitempty"`
+	Mappings []jsonMapping `json:"mappings"`
+}
+
+// WriteJSON writes sm to w as indented JSON
+func (sm *SourceMap) WriteJSON(w io.Writer) error {
+	out := jsonSourceMap{File: sm.File, Mappings: make([]jsonMapping, len(sm.Mappings))}
+	for i, m := range sm.Mappings {
+		out.Mappings[i] = jsonMapping{
+			Go:       jsonRange{Start: m.GoStart, End: m.GoEnd},
+			Template: jsonRange{Name: m.Src.Name, Start: m.Src.Start, End: m.Src.End},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// ReadJSON reads a source map written by WriteJSON. The template slices it
+// holds have positions but no text.
+func ReadJSON(r io.Reader) (*SourceMap, error) {
+	var in jsonSourceMap
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, fmt.Errorf("sourcemap: %w", err)
+	}
+	sm := &SourceMap{File: in.File}
+	for _, jm := range in.Mappings {
+		sm.Mappings = append(sm.Mappings, Mapping{
+			GoStart: jm.Go.Start,
+			GoEnd:   jm.Go.End,
+			Src:     input.Slice{Name: jm.Template.Name, Start: jm.Template.Start, End: jm.Template.End},
+		})
+	}
+	return sm, nil
+}
+
+type goToken struct {
+	start, end int
+}
+
+// scan returns the tokens of src, leaving out comments and the semicolons Go
+// inserts at the end of lines. comments holds, for each //line comment, the
+// index of the token following it.
+func scan(file *gotoken.File, src string) (toks []goToken, comments []int) {
+	var s goscanner.Scanner
+	s.Init(file, []byte(src), nil, goscanner.ScanComments)
+	for {
+		p, tok, lit := s.Scan()
+		switch {
+		case tok == gotoken.EOF:
+			return toks, comments
+		case tok == gotoken.COMMENT:
+			if strings.HasPrefix(lit, "//line ") {
+				comments = append(comments, len(toks))
+			}
+			continue
+		case tok == gotoken.SEMICOLON && lit == "\n":
+			continue
+		}
+		start := file.Offset(p)
+		if lit == "" {
+			lit = tok.String()
+		}
+		toks = append(toks, goToken{start, start + len(lit)})
+	}
+}
+
+// countTokens returns the number of tokens and //line comments scan would
+// find in code
+func countTokens(code string) (toks, comments int) {
+	fset := gotoken.NewFileSet()
+	t, c := scan(fset.AddFile("", fset.Base(), len(code)), code)
+	return len(t), len(c)
+}
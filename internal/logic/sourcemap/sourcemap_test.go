@@ -0,0 +1,245 @@
+package sourcemap
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+)
+
+func TestBuild(t *testing.T) {
+	in := input.NewInput("test", "◊{ x := 1 }◊{ if x >\n1 { }")
+	segs := []interfaces.Segment{
+		{Src: in.SliceAt(4, 12), Code: " x := 1 "},
+		{Src: in.SliceAt(18, 29), Code: " if x >\n1 { "},
+		{Src: in.SliceAt(29, 30), Code: "}"},
+	}
+	// As formatted, with the if reflowed onto one line
+	goCode := `package main
+
+func main() {
+//line test:1
+	x := 1
+//line test:1
+	if x > 1 {
+//line test:2
+	}
+}
+`
+	sm, err := Build(goCode, segs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	for _, m := range sm.Mappings {
+		c.Printf("%q => %q\n", goCode[m.GoStart.Idx:m.GoEnd.Idx], m.Src.S)
+	}
+	c.PrintSection("lookup")
+	m, found := sm.Lookup(len("package main\n\nfunc main() {\n//line test:1\n\tx := 1\n//line test:1\n\tif x"))
+	c.Println(m.Src, found)
+	_, found = sm.Lookup(len(goCode) - 1)
+	c.Println(found)
+	c.PrintSection("json")
+	var buf bytes.Buffer
+	_ = sm.WriteJSON(&buf)
+	c.Print(buf.String())
+	c.Expect(`
+		"x := 1" => " x := 1 "
+		"if x > 1 {" => "if x >\n1 { "
+		"}" => "}"
+		################################################################################
+		# lookup
+		################################################################################
+		test:1 - "if x >\n1 { " true
+		false
+		################################################################################
+		# json
+		################################################################################
+		{
+		  "mappings": [
+		    {
+		      "go": {
+		        "start": {
+		          "offset": 43,
+		          "line": 5,
+		          "col": 2,
+		          "runeCol": 2,
+		          "displayCol": 9
+		        },
+		        "end": {
+		          "offset": 49,
+		          "line": 5,
+		          "col": 8,
+		          "runeCol": 8,
+		          "displayCol": 15
+		        }
+		      },
+		      "template": {
+		        "name": "test",
+		        "start": {
+		          "offset": 4,
+		          "line": 1,
+		          "col": 5,
+		          "runeCol": 3,
+		          "displayCol": 3
+		        },
+		        "end": {
+		          "offset": 12,
+		          "line": 1,
+		          "col": 13,
+		          "runeCol": 11,
+		          "displayCol": 11
+		        }
+		      }
+		    },
+		    {
+		      "go": {
+		        "start": {
+		          "offset": 65,
+		          "line": 7,
+		          "col": 2,
+		          "runeCol": 2,
+		          "displayCol": 9
+		        },
+		        "end": {
+		          "offset": 75,
+		          "line": 7,
+		          "col": 12,
+		          "runeCol": 12,
+		          "displayCol": 19
+		        }
+		      },
+		      "template": {
+		        "name": "test",
+		        "start": {
+		          "offset": 18,
+		          "line": 1,
+		          "col": 19,
+		          "runeCol": 15,
+		          "displayCol": 15
+		        },
+		        "end": {
+		          "offset": 29,
+		          "line": 2,
+		          "col": 5,
+		          "runeCol": 5,
+		          "displayCol": 5
+		        }
+		      }
+		    },
+		    {
+		      "go": {
+		        "start": {
+		          "offset": 91,
+		          "line": 9,
+		          "col": 2,
+		          "runeCol": 2,
+		          "displayCol": 9
+		        },
+		        "end": {
+		          "offset": 92,
+		          "line": 9,
+		          "col": 3,
+		          "runeCol": 3,
+		          "displayCol": 10
+		        }
+		      },
+		      "template": {
+		        "name": "test",
+		        "start": {
+		          "offset": 29,
+		          "line": 2,
+		          "col": 5,
+		          "runeCol": 5,
+		          "displayCol": 5
+		        },
+		        "end": {
+		          "offset": 30,
+		          "line": 2,
+		          "col": 6,
+		          "runeCol": 6,
+		          "displayCol": 6
+		        }
+		      }
+		    }
+		  ]
+		}
+		`)
+}
+
+func TestBuild_lineCommentInCode(t *testing.T) {
+	in := input.NewInput("test", "◊{ x := 1\n//line other.go:9\ny := x }◊{ _ = y }")
+	segs := []interfaces.Segment{
+		{Src: in.SliceAt(4, 37), Code: " x := 1\n//line other.go:9\ny := x "},
+		{Src: in.SliceAt(42, 49), Code: " _ = y "},
+	}
+	goCode := `package main
+
+func main() {
+//line test:1
+	x := 1
+//line other.go:9
+	y := x
+//line test:3
+	_ = y
+}
+`
+	sm, err := Build(goCode, segs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ic.New(t)
+	for _, m := range sm.Mappings {
+		c.Printf("%q => %q\n", goCode[m.GoStart.Idx:m.GoEnd.Idx], m.Src.S)
+	}
+	c.Expect(`
+		"x := 1\n//line other.go:9\n\ty := x" => " x := 1\n//line other.go:9\ny := x "
+		"_ = y" => " _ = y "
+		`)
+}
+
+func TestReadJSON(t *testing.T) {
+	in := input.NewInput("test", "hi")
+	want := &SourceMap{
+		File: "test.go",
+		Mappings: []Mapping{{
+			GoStart: input.Pos{Idx: 40, Row: 5, Col: 2},
+			GoEnd:   input.Pos{Idx: 61, Row: 5, Col: 23},
+			Src:     in.SliceAt(0, 2),
+		}},
+	}
+	var buf bytes.Buffer
+	if err := want.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the positions of the template slice are kept
+	want.Mappings[0].Src.S = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
+
+func TestBuild_errorCases(t *testing.T) {
+	t.Run("missing comment", func(t *testing.T) {
+		in := input.NewInput("test", "hi")
+		segs := []interfaces.Segment{
+			{Src: in.SliceAt(0, 2), Code: `buf.WriteString("hi")`},
+		}
+		_, err := Build("package main\n", segs)
+
+		c := ic.New(t)
+		c.Println(err)
+		c.Expect(`
+			sourcemap: found 0 //line comments, want 1 for 1 segments
+			`)
+	})
+}
@@ -0,0 +1,76 @@
+// Package suggest finds the known names closest to one that wasn't found, for
+// "did you mean" hints.
+package suggest
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// Closest returns the names in known within a few edits of name, closest
+// first. Names equally close are sorted. The number of edits allowed grows
+// with the length of name, a third of its runes rounded up.
+func Closest(name string, known []string) []string {
+	limit := (utf8.RuneCountInString(name) + 2) / 3
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	for _, k := range known {
+		if d := Distance(name, k); d <= limit && k != name {
+			matches = append(matches, match{k, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.name
+	}
+	return names
+}
+
+// Distance returns the number of runes that need to be inserted, deleted,
+// replaced or swapped with their neighbour to turn a into b. Swaps count as
+// one edit, as they're such a common typo.
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// d[i][j] is the distance between the first i runes of a and the first j
+	// of b
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, n := range rest {
+		if n < m {
+			m = n
+		}
+	}
+	return m
+}
@@ -0,0 +1,54 @@
+package suggest
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+)
+
+func TestDistance(t *testing.T) {
+	c := ic.New(t)
+	for _, pair := range [][2]string{
+		{"for", "for"},
+		{"fro", "for"},
+		{"fo", "for"},
+		{"forr", "for"},
+		{"fir", "for"},
+		{"improt", "import"},
+		{"◊if", "if"},
+		{"", "if"},
+		{"if", "for"},
+	} {
+		c.Printf("%-8q %-8q %d\n", pair[0], pair[1], Distance(pair[0], pair[1]))
+	}
+	c.Expect(`
+		"for"    "for"    0
+		"fro"    "for"    1
+		"fo"     "for"    1
+		"forr"   "for"    1
+		"fir"    "for"    1
+		"improt" "import" 1
+		"◊if"    "if"     1
+		""       "if"     2
+		"if"     "for"    3
+		`)
+}
+
+func TestClosest(t *testing.T) {
+	known := []string{"for", "if", "import", "raw", "define"}
+
+	c := ic.New(t)
+	for _, name := range []string{"fro", "fi", "improt", "row", "defnie", "x", "elephant", "for"} {
+		c.Printf("%-8q %q\n", name, Closest(name, known))
+	}
+	c.Expect(`
+		"fro"    ["for"]
+		"fi"     ["if"]
+		"improt" ["import"]
+		"row"    ["raw"]
+		"defnie" ["define"]
+		"x"      []
+		"elephant" []
+		"for"    []
+		`)
+}
@@ -0,0 +1,83 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key names a typed value attached to a token. Keys are matched by name, so
+// macros should prefix theirs with the macro name (e.g. "for.vars").
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Get returns the value stored under k. found is false if t has no value for
+// k or it holds a value of a different type.
+func (k Key[T]) Get(t *Token) (v T, found bool) {
+	for _, a := range t.attrs {
+		if a.Key == k.name {
+			v, found = a.Value.(T)
+			return v, found
+		}
+	}
+	return v, false
+}
+
+// Set stores v under k, replacing any value already there
+func (k Key[T]) Set(t *Token, v T) {
+	for i, a := range t.attrs {
+		if a.Key == k.name {
+			t.attrs[i].Value = v
+			return
+		}
+	}
+	t.attrs = append(t.attrs, Attr{Key: k.name, Value: v})
+}
+
+// Has reports whether t has a value stored under k
+func (k Key[T]) Has(t *Token) bool {
+	_, found := k.Get(t)
+	return found
+}
+
+// Get is shorthand for k.Get(t)
+func Get[T any](t *Token, k Key[T]) (T, bool) {
+	return k.Get(t)
+}
+
+// Set is shorthand for k.Set(t, v)
+func Set[T any](t *Token, k Key[T], v T) {
+	k.Set(t, v)
+}
+
+// Attr is a single value attached to a token
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Attrs are the values attached to a token, in the order they were first set
+type Attrs []Attr
+
+func (as Attrs) String() string {
+	if len(as) == 0 {
+		return ""
+	}
+	parts := make([]string, len(as))
+	for i, a := range as {
+		if s, ok := a.Value.(string); ok {
+			parts[i] = fmt.Sprintf("%s=%q", a.Key, s)
+		} else {
+			parts[i] = fmt.Sprintf("%s=%v", a.Key, a.Value)
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
@@ -0,0 +1,50 @@
+package token
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	t.Run("get and set", func(t *testing.T) {
+		vars := NewKey[[]string]("test.vars")
+		tok := &Token{TT: TTcodeLocalBlock}
+		if _, found := vars.Get(tok); found {
+			t.Fatalf("found value before it was set")
+		}
+		vars.Set(tok, []string{"i", "v"})
+		got, found := vars.Get(tok)
+		if !found || !reflect.DeepEqual(got, []string{"i", "v"}) {
+			t.Errorf("got %v (found %t) want [i v]", got, found)
+		}
+	})
+	t.Run("set replaces", func(t *testing.T) {
+		kind := NewKey[string]("test.kind")
+		tok := &Token{TT: TTcodeLocalBlock}
+		Set(tok, kind, "if")
+		Set(tok, kind, "else")
+		got, _ := Get(tok, kind)
+		if got != "else" {
+			t.Errorf("got %q want %q", got, "else")
+		}
+		if len(tok.Attrs()) != 1 {
+			t.Errorf("got %d attrs want 1", len(tok.Attrs()))
+		}
+	})
+	t.Run("same name different type", func(t *testing.T) {
+		tok := &Token{TT: TTcodeLocalBlock}
+		NewKey[string]("test.val").Set(tok, "1")
+		if NewKey[int]("test.val").Has(tok) {
+			t.Errorf("int key found string value")
+		}
+	})
+	t.Run("attrs are a copy", func(t *testing.T) {
+		kind := NewKey[string]("test.kind")
+		tok := &Token{TT: TTcodeLocalBlock}
+		kind.Set(tok, "if")
+		tok.Attrs()[0].Value = "else"
+		if got, _ := kind.Get(tok); got != "if" {
+			t.Errorf("got %q want %q", got, "if")
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package token
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+)
+
+type jsonToken struct {
+	Type  string         `json:"type"`
+	Text  string         `json:"text"`
+	Start input.Pos      `json:"start"`
+	End   input.Pos      `json:"end"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// FprintJSON writes toks to w as an indented JSON array. Custom token types
+// are named using r, which may be nil.
+func FprintJSON(w io.Writer, r *Registry, toks []*Token) error {
+	out := make([]jsonToken, len(toks))
+	for i, tok := range toks {
+		jt := jsonToken{
+			Type:  tok.TT.String(),
+			Text:  tok.Slc.S,
+			Start: tok.Slc.Start,
+			End:   tok.Slc.End,
+		}
+		if r != nil {
+			jt.Type = r.Name(tok.TT)
+		}
+		if len(tok.attrs) > 0 {
+			jt.Attrs = make(map[string]any, len(tok.attrs))
+			for _, a := range tok.attrs {
+				jt.Attrs[a.Key] = a.Value
+			}
+		}
+		out[i] = jt
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,72 @@
+package token
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/input"
+)
+
+func TestFprintJSON(t *testing.T) {
+	r := NewRegistry()
+	ttEnd := r.Namespace("shout").Register("End")
+	in := input.NewInput("test", "if x {◊}")
+	head := NewToken(TTcodeLocalBlock, in.SliceAt(0, 6))
+	NewKey[string]("if.branch").Set(head, "if")
+	toks := []*Token{
+		head,
+		NewToken(ttEnd, in.SliceAt(6, 10)),
+	}
+
+	var buf bytes.Buffer
+	err := FprintJSON(&buf, r, toks)
+
+	c := ic.New(t)
+	c.Print(buf.String())
+	c.Println(err)
+	c.Expect(`
+		[
+		  {
+		    "type": "TT.CodeLocalBlock",
+		    "text": "if x {",
+		    "start": {
+		      "offset": 0,
+		      "line": 1,
+		      "col": 1,
+		      "runeCol": 1,
+		      "displayCol": 1
+		    },
+		    "end": {
+		      "offset": 6,
+		      "line": 1,
+		      "col": 7,
+		      "runeCol": 7,
+		      "displayCol": 7
+		    },
+		    "attrs": {
+		      "if.branch": "if"
+		    }
+		  },
+		  {
+		    "type": "TT.shout.End",
+		    "text": "◊}",
+		    "start": {
+		      "offset": 6,
+		      "line": 1,
+		      "col": 7,
+		      "runeCol": 7,
+		      "displayCol": 7
+		    },
+		    "end": {
+		      "offset": 10,
+		      "line": 1,
+		      "col": 11,
+		      "runeCol": 9,
+		      "displayCol": 9
+		    }
+		  }
+		]
+		<nil>
+		`)
+}
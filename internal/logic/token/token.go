@@ -7,26 +7,27 @@ import (
 )
 
 type Token struct {
-	TT  TokenType
-	Slc input.Slice
-	E   *any
+	TT    TokenType
+	Slc   input.Slice
+	attrs Attrs
 }
 
 func NewToken(tt TokenType, s input.Slice) *Token {
 	return &Token{TT: tt, Slc: s}
 }
 
+// Attrs returns a copy of the values attached to the token
+func (t Token) Attrs() Attrs {
+	if len(t.attrs) == 0 {
+		return nil
+	}
+	return append(Attrs(nil), t.attrs...)
+}
+
 func (t Token) String() string {
-	var str, extra string
+	var str string
 	if t.Slc.S != "" {
 		str = fmt.Sprintf("(%q)", t.Slc.S)
 	}
-	if t.E != nil {
-		if stringer, ok := (*t.E).(fmt.Stringer); ok {
-			extra = fmt.Sprintf("[%s]", stringer.String())
-		} else {
-			extra = fmt.Sprintf("[%#v]", *t.E)
-		}
-	}
-	return fmt.Sprintf("%s%s%s", t.TT, str, extra)
+	return fmt.Sprintf("%s%s%s", t.TT, str, t.attrs)
 }
@@ -13,27 +13,31 @@ func TestToken_String(t *testing.T) {
 	mkSlc := func(s string) input.Slice {
 		return input.Slice{S: s}
 	}
-	var data any = "extra-data"
+	withAttrs := func(tok *Token) *Token {
+		NewKey[string]("extra").Set(tok, "data")
+		NewKey[int]("n").Set(tok, 2)
+		return tok
+	}
 	c.PT([]struct {
 		Name string
 		Tok  *Token
 	}{
-		{"with S no E", &Token{TTnl, mkSlc("\n"), nil}},
-		{"no S no E", &Token{TTcustom, mkSlc(""), nil}},
-		{"with S with E", &Token{TTcustom, mkSlc("foo"), &data}},
-		{"no S with E", &Token{TTcustom, mkSlc(""), &data}},
+		{"with S no attrs", &Token{TT: TTnl, Slc: mkSlc("\n")}},
+		{"no S no attrs", &Token{TT: TTcustom, Slc: mkSlc("")}},
+		{"with S with attrs", withAttrs(&Token{TT: TTcustom, Slc: mkSlc("foo")})},
+		{"no S with attrs", withAttrs(&Token{TT: TTcustom, Slc: mkSlc("")})},
 	})
 
 	c.Expect(`
-		   | Name            | Tok                            |
-		---+-----------------+--------------------------------+
-		 1 | "with S no E"   | TT.NL("\n")                    |
-		---+-----------------+--------------------------------+
-		 2 | "no S no E"     | TT.Custom                      |
-		---+-----------------+--------------------------------+
-		 3 | "with S with E" | TT.Custom("foo")["extra-data"] |
-		---+-----------------+--------------------------------+
-		 4 | "no S with E"   | TT.Custom["extra-data"]        |
-		---+-----------------+--------------------------------+
+		   | Name                | Tok                                |
+		---+---------------------+------------------------------------+
+		 1 | "with S no attrs"   | TT.NL("\n")                        |
+		---+---------------------+------------------------------------+
+		 2 | "no S no attrs"     | TT.Custom                          |
+		---+---------------------+------------------------------------+
+		 3 | "with S with attrs" | TT.Custom("foo")[extra="data" n=2] |
+		---+---------------------+------------------------------------+
+		 4 | "no S with attrs"   | TT.Custom[extra="data" n=2]        |
+		---+---------------------+------------------------------------+
 		`)
 }
@@ -8,12 +8,17 @@ import (
 
 type TokenType int
 
+// String returns the name of a built-in token type. Custom token types are
+// named by the Registry they came from, so they're only given a placeholder
+// name here.
 func (t TokenType) String() string {
-	customTT, found := globalRegistry.Lookup(t)
-	if !found {
-		panic(fmt.Sprintf("unrecognized TokenType with value %d", t))
+	if s, found := builtinNames[t]; found {
+		return s
 	}
-	return customTT
+	if t.IsCustom() {
+		return fmt.Sprintf("TT.Custom(%d)", int(t))
+	}
+	return fmt.Sprintf("TT.Invalid(%d)", int(t))
 }
 
 func (t TokenType) IsCustom() bool {
@@ -34,26 +39,34 @@ const (
 	// Any custom types should be > 999
 )
 
+var builtinNames = map[TokenType]string{
+	TTunknown:         "TT.Unknown",
+	TTws:              "TT.WS",
+	TTnl:              "TT.NL",
+	TTcontent:         "TT.Content",
+	TTcodeGlobalBlock: "TT.CodeGlobalBlock",
+	TTcodeLocalBlock:  "TT.CodeLocalBlock",
+	TTcodeLocalExpr:   "TT.CodeLocalExpr",
+	TTmacro:           "TT.Macro",
+	TTcustom:          "TT.Custom",
+}
+
 func NewRegistry() *Registry {
-	tr := Registry{idx: TTcustom}
-	baseRegistry := map[TokenType]string{
-		TTunknown:         "TT.Unknown",
-		TTws:              "TT.WS",
-		TTnl:              "TT.NL",
-		TTcontent:         "TT.Content",
-		TTcodeGlobalBlock: "TT.CodeGlobalBlock",
-		TTcodeLocalBlock:  "TT.CodeLocalBlock",
-		TTcodeLocalExpr:   "TT.CodeLocalExpr",
-		TTmacro:           "TT.Macro",
-		TTcustom:          "TT.Custom",
+	tr := Registry{idx: TTcustom, ids: make(map[string]TokenType)}
+	baseRegistry := make(map[TokenType]string)
+	for tt, name := range builtinNames {
+		baseRegistry[tt] = name
 	}
 	tr.registry.Store(baseRegistry)
 	return &tr
 }
 
+// Registry hands out custom token types. Each LozengeTemplate has its own, so
+// the same TokenType value may name different types in different templates.
 type Registry struct {
 	mu       sync.Mutex
-	idx      int // only change with mutex
+	idx      int                  // only change with mutex
+	ids      map[string]TokenType // only change with mutex
 	registry atomic.Value
 }
 
@@ -63,10 +76,23 @@ func (tr *Registry) Lookup(tt TokenType) (string, bool) {
 	return s, found
 }
 
+// Name returns the name tt was registered with, falling back to tt.String()
+func (tr *Registry) Name(tt TokenType) string {
+	if s, found := tr.Lookup(tt); found {
+		return s
+	}
+	return tt.String()
+}
+
+// Register returns the token type for name, adding it if it hasn't been
+// registered already
 func (tr *Registry) Register(name string) TokenType {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 
+	if tt, found := tr.ids[name]; found {
+		return tt
+	}
 	tr.idx++
 	tt := TokenType(tr.idx)
 	m1 := tr.registry.Load().(map[TokenType]string)
@@ -76,11 +102,23 @@ func (tr *Registry) Register(name string) TokenType {
 	}
 	m2[tt] = `TT.` + name
 	tr.registry.Store(m2)
+	tr.ids[name] = tt
 	return tt
 }
 
-var globalRegistry = NewRegistry()
+// Namespace returns a Namespace registering token types under prefix
+func (tr *Registry) Namespace(prefix string) Namespace {
+	return Namespace{r: tr, prefix: prefix}
+}
+
+// Namespace registers token types with a Registry under a common prefix,
+// normally the name of the macro using them, so that macros can't clash.
+type Namespace struct {
+	r      *Registry
+	prefix string
+}
 
-func RegisterCustomTokenType(name string) TokenType {
-	return globalRegistry.Register(name)
+// Register returns the token type for prefix.name, e.g. TT.import.Import
+func (ns Namespace) Register(name string) TokenType {
+	return ns.r.Register(ns.prefix + "." + name)
 }
@@ -6,15 +6,46 @@ import (
 	"testing"
 )
 
-func TestRegisterCustom(t *testing.T) {
+func TestRegistry_Register(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
-		myCustom := RegisterCustomTokenType("MyCustom")
-		got := myCustom.String()
+		r := NewRegistry()
+		myCustom := r.Register("MyCustom")
+		got := r.Name(myCustom)
 		want := "TT.MyCustom"
 		if got != want {
 			t.Errorf("got %q want %q", got, want)
 		}
 	})
+	t.Run("registering twice", func(t *testing.T) {
+		r := NewRegistry()
+		first := r.Register("MyCustom")
+		second := r.Register("MyCustom")
+		if first != second {
+			t.Errorf("got %d and %d want the same token type", first, second)
+		}
+	})
+	t.Run("namespaced", func(t *testing.T) {
+		r := NewRegistry()
+		a := r.Namespace("a").Register("Start")
+		b := r.Namespace("b").Register("Start")
+		if a == b {
+			t.Errorf("got %d for both namespaces", a)
+		}
+		if got, want := r.Name(b), "TT.b.Start"; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	})
+	t.Run("registries are separate", func(t *testing.T) {
+		r1 := NewRegistry()
+		r2 := NewRegistry()
+		tt := r1.Register("MyCustom")
+		if _, found := r2.Lookup(tt); found {
+			t.Errorf("found %s in another registry", r1.Name(tt))
+		}
+		if got, want := r2.Name(tt), tt.String(); got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	})
 }
 
 func TestTokenType_String(t *testing.T) {
@@ -35,33 +66,18 @@ func TestTokenType_String(t *testing.T) {
 	})
 	t.Run("unset token type", func(t *testing.T) {
 		invalidTokenType := TokenType(maxTokenTypeId + 1)
-		wantMessage := fmt.Sprintf("unrecognized TokenType with value %d", invalidTokenType)
-		assertPanicsWithMessage(t, wantMessage, func() {
-			s := invalidTokenType.String()
-			t.Errorf("got %q want to panic", s)
-		})
+		got := invalidTokenType.String()
+		want := fmt.Sprintf("TT.Invalid(%d)", maxTokenTypeId+1)
+		if got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
 	})
 	t.Run("custom token type", func(t *testing.T) {
-		customTokenType := RegisterCustomTokenType("MyCustom")
+		customTokenType := NewRegistry().Register("MyCustom")
 		got := customTokenType.String()
-		want := "TT.MyCustom"
+		want := fmt.Sprintf("TT.Custom(%d)", customTokenType)
 		if got != want {
 			t.Errorf("got %q want %q", got, want)
 		}
 	})
 }
-
-func assertPanicsWithMessage(t *testing.T, msg string, f func()) {
-	t.Helper()
-	defer func() {
-		t.Helper()
-		r := recover()
-		if r == nil {
-			t.Fatalf("The code did not panic")
-		}
-		if r != msg {
-			t.Fatalf("\n got panic %q\nwant panic %q", r, msg)
-		}
-	}()
-	f()
-}
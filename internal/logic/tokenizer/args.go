@@ -0,0 +1,130 @@
+package tokenizer
+
+import (
+	"fmt"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"regexp"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+var bodyOpenRegex = regexp.MustCompile(`^[ \t]*\{◊`)
+
+// ReadMacroArgs reads the macro called name from the start of in, along with
+// any arguments in brackets and body in "{◊ ◊}" following it. Arguments are
+// split on the commas between them and may be given as key=value, though
+// those given by position must come first.
+func (ct *ContentTokenizer) ReadMacroArgs(in *input.Input, name string) (*interfaces.MacroArgs, error) {
+	nameSlc, found := in.ConsumeString(name)
+	if !found {
+		return nil, in.ErrorHere(fmt.Errorf("expected macro %q", name))
+	}
+	ma := &interfaces.MacroArgs{Name: nameSlc}
+	if r, found := in.Peek(); found && r == '(' {
+		toks, err := ct.ParseGoCodeFromTo(in, token.TTcodeLocalExpr, '(', ')', true)
+		if err != nil {
+			return nil, err
+		}
+		ma.List = toks[0].Slc
+		if ma.Args, err = splitArgs(in, ma.List); err != nil {
+			return nil, err
+		}
+	}
+	if open, found := in.ConsumeRegexp(bodyOpenRegex); found {
+		ma.Open = in.SliceAt(open.End.Idx-len("{◊"), open.End.Idx)
+		body, err := ct.ReadTokensUntil(in, "◊}")
+		if err != nil {
+			return nil, err
+		}
+		ma.Body = body
+		ma.Close, _ = in.ConsumeString("◊}")
+	}
+	return ma, nil
+}
+
+// splitArgs splits list, a bracketed list of arguments, on the commas
+// outside any brackets or strings of its own. A trailing comma is allowed,
+// as in Go.
+func splitArgs(in *input.Input, list input.Slice) ([]interfaces.Arg, error) {
+	// Offsets are from the start of the template
+	base := list.Start.Idx + len("(")
+	src := list.S[1 : len(list.S)-1]
+
+	var args []interfaces.Arg
+	var depth, start int
+	var seenKey bool
+	keys := make(map[string]bool)
+	addArg := func(end int, last bool) error {
+		text := src[start:end]
+		from := base + start + len(text) - len(strings.TrimLeft(text, " \t\r\n"))
+		to := base + start + len(strings.TrimRight(text, " \t\r\n"))
+		if from >= to {
+			// Either there are no arguments or the last is followed by a comma
+			if last && (len(args) > 0 || strings.TrimSpace(src) == "") {
+				return nil
+			}
+			return in.ErrorAt(from, from, fmt.Errorf("missing argument"))
+		}
+		arg := interfaces.Arg{Value: in.SliceAt(from, to)}
+		if key, value, isNamed := splitKey(arg.Value.S); isNamed {
+			arg.Key = in.SliceAt(from, from+len(key))
+			if value == "" {
+				return in.ErrorAt(from, to, fmt.Errorf("missing value for argument %q", key))
+			}
+			arg.Value = in.SliceAt(to-len(value), to)
+			if keys[key] {
+				return in.ErrorAt(arg.Key.Start.Idx, arg.Key.End.Idx, fmt.Errorf("argument %q given more than once", key))
+			}
+			keys[key] = true
+			seenKey = true
+		} else if seenKey {
+			return in.ErrorAt(from, to, fmt.Errorf("positional argument after key=value arguments"))
+		}
+		args = append(args, arg)
+		return nil
+	}
+
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s goscanner.Scanner
+	// Errors are left for the Go compiler to report
+	s.Init(file, []byte(src), nil, 0)
+	for {
+		pos, tok, _ := s.Scan()
+		switch tok {
+		case gotoken.EOF:
+			if err := addArg(len(src), true); err != nil {
+				return nil, err
+			}
+			return args, nil
+		case gotoken.LPAREN, gotoken.LBRACK, gotoken.LBRACE:
+			depth++
+		case gotoken.RPAREN, gotoken.RBRACK, gotoken.RBRACE:
+			depth--
+		case gotoken.COMMA:
+			if depth == 0 {
+				end := file.Offset(pos)
+				if err := addArg(end, false); err != nil {
+					return nil, err
+				}
+				start = end + len(",")
+			}
+		}
+	}
+}
+
+var keyRegex = regexp.MustCompile(`^([\pL_][\pL\pN_]*)\s*=\s*`)
+
+// splitKey splits an argument given as key=value. An argument comparing
+// with == isn't one.
+func splitKey(arg string) (key, value string, isNamed bool) {
+	m := keyRegex.FindStringSubmatch(arg)
+	if m == nil || strings.HasPrefix(arg[len(m[0]):], "=") {
+		return "", "", false
+	}
+	return m[1], arg[len(m[0]):], true
+}
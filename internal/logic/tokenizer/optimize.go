@@ -1,82 +1,125 @@
 package tokenizer
 
 import (
-	"fmt"
-
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
 func Optimize(toks []*token.Token, trimSpaces bool) []*token.Token {
-	newToks := make([]*token.Token, 0)
-	curTok := func() *token.Token {
-		if len(newToks) == 0 {
-			return nil
-		}
-		return newToks[len(newToks)-1]
-	}
-	appendNewToks := func(tok *token.Token, joinNewline bool) {
-		if cur := curTok(); cur != nil && cur.TT == tok.TT && cur.Slc.CanJoin(tok.Slc) {
-			curSlcLen := cur.Slc.Len()
-			lastIsNL := cur.Slc.S[len(cur.Slc.S)-1] == '\n'
-			if lastIsNL || curSlcLen+tok.Slc.Len() > 60 {
-				newToks = append(newToks, tok)
-			} else {
-				cur.Slc = cur.Slc.Join(tok.Slc)
-			}
-		} else {
-			newToks = append(newToks, tok)
-		}
+	o := NewOptimizer(trimSpaces)
+	newToks := o.Push(toks...)
+	return append(newToks, o.Flush()...)
+}
+
+// Optimizer is Optimize for tokens arriving a few at a time. It holds back
+// any tokens it can't be sure of yet, such as content that may be joined with
+// whatever comes next.
+type Optimizer struct {
+	trimSpaces bool
+	// pending holds the tokens pushed but not yet looked at
+	pending []*token.Token
+	// cur is the last token written, which the next token may join. out
+	// holds those written before it, yet to be returned.
+	cur              *token.Token
+	out              []*token.Token
+	prevRealIsCode   bool
+	alreadyTrimmedNL bool
+}
+
+func NewOptimizer(trimSpaces bool) *Optimizer {
+	return &Optimizer{trimSpaces: trimSpaces}
+}
+
+// Push adds toks, returning the optimized tokens now known for certain
+func (o *Optimizer) Push(toks ...*token.Token) []*token.Token {
+	o.pending = append(o.pending, toks...)
+	o.run(false)
+	return o.output()
+}
+
+// Flush returns everything held back, as if the tokens had ended. Later
+// tokens may still be pushed, but won't be joined with those already
+// returned.
+func (o *Optimizer) Flush() []*token.Token {
+	o.run(true)
+	if o.cur != nil {
+		o.out = append(o.out, o.cur)
+		o.cur = nil
 	}
-	var alreadyTrimmedNL bool
-	for i := 0; i < len(toks); i++ {
-		tok := toks[i]
+	return o.output()
+}
+
+func (o *Optimizer) output() []*token.Token {
+	out := o.out
+	o.out = nil
+	return out
+}
+
+func (o *Optimizer) run(final bool) {
+	var i int
+loop:
+	for ; i < len(o.pending); i++ {
+		tok := o.pending[i]
 		switch tok.TT {
 		case token.TTcontent:
-			appendNewToks(tok, false)
+			o.appendNewToks(tok, true)
 		case token.TTws:
-			if trimSpaces && isNextRealTokenCodeBlock(toks[i:]) {
-				continue
+			if o.trimSpaces {
+				isCodeBlock, known := isNextRealTokenCodeBlock(o.pending[i:], final)
+				if !known {
+					break loop
+				}
+				if isCodeBlock {
+					continue
+				}
 			}
 			tok = token.NewToken(token.TTcontent, tok.Slc)
-			appendNewToks(tok, false)
+			o.appendNewToks(tok, true)
 		case token.TTnl:
-			if trimSpaces && isPrevRealTokenCodeBlock(newToks) && !alreadyTrimmedNL {
-				alreadyTrimmedNL = true
+			if o.trimSpaces && o.prevRealIsCode && !o.alreadyTrimmedNL {
+				o.alreadyTrimmedNL = true
 				continue
 			}
 			tok = token.NewToken(token.TTcontent, tok.Slc)
-			appendNewToks(tok, false)
+			o.appendNewToks(tok, true)
 		case token.TTcodeGlobalBlock, token.TTcodeLocalBlock:
-			appendNewToks(tok, true)
+			o.appendNewToks(tok, true)
 		default:
-			newToks = append(newToks, tok)
+			o.appendNewToks(tok, false)
 		}
-		alreadyTrimmedNL = false
+		o.alreadyTrimmedNL = false
 	}
-	return newToks
+	o.pending = append([]*token.Token(nil), o.pending[i:]...)
 }
 
-func isNextRealTokenCodeBlock(toks []*token.Token) bool {
-	for _, tok := range toks {
-		if tok.TT.IsCustom() || tok.TT == token.TTmacro || tok.TT == token.TTws {
-			continue
+// appendNewToks writes tok, joining it to the last token written when
+// canJoin is set and they're next to each other
+func (o *Optimizer) appendNewToks(tok *token.Token, canJoin bool) {
+	if cur := o.cur; canJoin && cur != nil && cur.TT == tok.TT && cur.Slc.CanJoin(tok.Slc) {
+		curSlcLen := cur.Slc.Len()
+		lastIsNL := cur.Slc.S[len(cur.Slc.S)-1] == '\n'
+		if !lastIsNL && curSlcLen+tok.Slc.Len() <= 60 {
+			cur.Slc = cur.Slc.Join(tok.Slc)
+			return
 		}
-		return tok.TT == token.TTcodeGlobalBlock || tok.TT == token.TTcodeLocalBlock
 	}
-	return false
+	if o.cur != nil {
+		o.out = append(o.out, o.cur)
+	}
+	o.cur = tok
+	if !tok.TT.IsCustom() && tok.TT != token.TTmacro {
+		o.prevRealIsCode = tok.TT == token.TTcodeGlobalBlock || tok.TT == token.TTcodeLocalBlock
+	}
 }
 
-func isPrevRealTokenCodeBlock(toks []*token.Token) bool {
-	if len(toks) == 0 {
-		return false
-	}
-	for i := len(toks) - 1; i >= 0; i-- {
-		tok := toks[i]
-		if tok.TT.IsCustom() || tok.TT == token.TTmacro {
-			fmt.Println("skipping custom or macro")
+// isNextRealTokenCodeBlock looks past any macros, custom tokens and
+// whitespace at the start of toks. known is false when toks runs out first
+// and more may still be pushed.
+func isNextRealTokenCodeBlock(toks []*token.Token, final bool) (isCodeBlock, known bool) {
+	for _, tok := range toks {
+		if tok.TT.IsCustom() || tok.TT == token.TTmacro || tok.TT == token.TTws {
 			continue
 		}
-		return tok.TT == token.TTcodeGlobalBlock || tok.TT == token.TTcodeLocalBlock
+		return tok.TT == token.TTcodeGlobalBlock || tok.TT == token.TTcodeLocalBlock, true
 	}
-	return false
+	return false, final
 }
@@ -7,12 +7,14 @@ import (
 
 	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 )
 
 type ContentTokenizer struct {
-	loz    rune
-	macros *interfaces.Macros
+	loz        rune
+	macros     *interfaces.Macros
+	tokenTypes *token.Registry
 }
 
 func NewDefault(macros *interfaces.Macros) *ContentTokenizer {
@@ -33,6 +35,7 @@ func (ct *ContentTokenizer) ReadAll(in *input.Input) ([]*token.Token, error) {
 func (ct *ContentTokenizer) ReadTokensUntil(in *input.Input, stopAt string) (tokens []*token.Token, err error) {
 	tokens = make([]*token.Token, 0)
 	var toks []*token.Token
+	startIdx := in.Pos().Idx
 	for {
 		if in.Consumed() {
 			break
@@ -51,15 +54,35 @@ func (ct *ContentTokenizer) ReadTokensUntil(in *input.Input, stopAt string) (tok
 		}
 	}
 	if stopAt != "" {
-		return nil, in.ErrorHere(fmt.Errorf("did not find %q", stopAt))
+		endIdx := in.Pos().Idx
+		return nil, in.ErrorAt(startIdx, endIdx, fmt.Errorf("did not find %q", stopAt),
+			input.Label{From: endIdx, To: endIdx, Msg: "input ended here"})
 	} else {
 		return tokens, nil
 	}
 }
 
+// Stream passes yield the tokens of in a group at a time, as they're read.
+// Each group is either a single token or a macro along with all of its
+// tokens. Text already tokenized is released as it goes, so an Input reading
+// from an io.Reader never holds much of it.
+func (ct *ContentTokenizer) Stream(in *input.Input, yield func(toks []*token.Token) error) error {
+	for !in.Consumed() {
+		toks, err := ct.NextTokens(in)
+		if err != nil {
+			return err
+		}
+		in.Release()
+		if err = yield(toks); err != nil {
+			return err
+		}
+	}
+	return in.Err()
+}
+
 func (ct *ContentTokenizer) NextTokens(in *input.Input) ([]*token.Token, error) {
 	var tt token.TokenType
-	var foundLoz bool
+	var foundLoz, afterCR bool
 	s, _ := in.TryReadWhile(func(r rune, last bool) (bool, error) {
 		switch r {
 		case ' ', '\t':
@@ -72,6 +95,8 @@ func (ct *ContentTokenizer) NextTokens(in *input.Input) ([]*token.Token, error)
 		case '\n':
 			if tt == token.TTunknown {
 				tt = token.TTnl
+			} else if afterCR {
+				afterCR = false
 			} else {
 				return false, nil
 			}
@@ -83,6 +108,17 @@ func (ct *ContentTokenizer) NextTokens(in *input.Input) ([]*token.Token, error)
 			} else {
 				return false, nil
 			}
+		case '\r':
+			// A "\r\n" line ending is a single newline
+			if in.HasPrefix("\r\n") {
+				if tt != token.TTunknown {
+					return false, nil
+				}
+				tt = token.TTnl
+				afterCR = true
+				return true, nil
+			}
+			fallthrough
 		default:
 			if tt == token.TTunknown {
 				tt = token.TTcontent
@@ -96,9 +132,15 @@ func (ct *ContentTokenizer) NextTokens(in *input.Input) ([]*token.Token, error)
 
 	if foundLoz {
 		return ct.parseLozenge(in)
-	} else {
-		return []*token.Token{token.NewToken(tt, s)}, nil
 	}
+	// An indented comment on a line of its own goes along with its indent
+	comment := string(ct.loz) + "#"
+	if tt == token.TTws && s.Start.Col == 1 && in.HasPrefix(comment) {
+		in.ConsumeString(comment)
+		ct.skipLineComment(in, true)
+		return nil, nil
+	}
+	return []*token.Token{token.NewToken(tt, s)}, nil
 }
 
 func (ct *ContentTokenizer) NextTokenCodeUntilOpenBraceLoz(in *input.Input) (*token.Token, error) {
@@ -124,6 +166,9 @@ func (ct *ContentTokenizer) NextTokenCodeUntilOpenBraceLoz(in *input.Input) (*to
 		} else if r == '\\' && inString {
 			if !escapeInQuote {
 				escapeInQuote = true
+				if last {
+					return false, fmt.Errorf("no open brace found")
+				}
 				return true, nil
 			}
 		} else if r == '"' && !inBackQuotes {
@@ -157,7 +202,7 @@ func (ct *ContentTokenizer) parseLozenge(in *input.Input) ([]*token.Token, error
 		return singletonLoz, nil
 	}
 	switch r {
-	case ' ', '\n':
+	case ' ', '\r', '\n':
 		return singletonLoz, nil
 	case '{':
 		return ct.ParseGoCodeFromTo(in, token.TTcodeLocalBlock, '{', '}', false)
@@ -166,6 +211,14 @@ func (ct *ContentTokenizer) parseLozenge(in *input.Input) ([]*token.Token, error
 	case '.':
 		in.Shift(r)
 		return ct.parseMacroIdentifier(loz, in)
+	case '#':
+		ct.skipLineComment(in, loz.Slc.Start.Col == 1)
+		return nil, nil
+	case '/':
+		if !in.HasPrefix("/*") {
+			return singletonLoz, nil
+		}
+		return nil, ct.skipBlockComment(loz, in)
 	case '^':
 		in.Shift(r)
 		r, found = in.Peek()
@@ -179,18 +232,62 @@ func (ct *ContentTokenizer) parseLozenge(in *input.Input) ([]*token.Token, error
 		in.Shift(ct.loz)
 		return singletonLoz, nil
 	default:
-		identifier := ct.readIdentifier(in)
-		if identifier.Len() == 0 {
+		expr, err := ct.readShortExpr(in)
+		if err != nil {
+			return nil, err
+		}
+		if expr.Len() == 0 {
 			return singletonLoz, nil
 		} else {
-			return []*token.Token{token.NewToken(token.TTcodeLocalExpr, identifier)}, nil
+			return []*token.Token{token.NewToken(token.TTcodeLocalExpr, expr)}, nil
+		}
+	}
+}
+
+// skipLineComment skips a "◊#" comment up to the newline ending it. When the
+// comment is on a line of its own the newline goes too, so that along with
+// NextTokens dropping the indent before one, the line leaves nothing behind.
+func (ct *ContentTokenizer) skipLineComment(in *input.Input, ownLine bool) {
+	in.ReadWhile(func(r rune) bool {
+		return r != '\n' && !in.HasPrefix("\r\n")
+	})
+	if ownLine {
+		if _, found := in.ConsumeString("\r\n"); !found {
+			in.Consume('\n')
+		}
+	}
+}
+
+// skipBlockComment skips a "◊/* */" comment, which may span lines. Like Go's
+// comments they don't nest.
+func (ct *ContentTokenizer) skipBlockComment(lozSlc *token.Token, in *input.Input) error {
+	start := lozSlc.Slc.Start.Idx
+	opening, _ := in.ConsumeString("/*")
+	var prev rune
+	var closed bool
+	comment, _ := in.TryReadWhile(func(r rune, last bool) (bool, error) {
+		if closed {
+			return false, nil
 		}
+		closed = prev == '*' && r == '/'
+		prev = r
+		return true, nil
+	})
+	if !closed {
+		end := comment.End.Idx
+		in.Seek(start)
+		return in.ErrorAt(start, end, fmt.Errorf("did not find end of comment %q", "*/"),
+			input.Label{From: start, To: opening.End.Idx, Msg: "opened here"},
+			input.Label{From: end, To: end, Msg: "input ended here"})
 	}
+	return nil
 }
 
 func (ct *ContentTokenizer) parseMacroIdentifier(lozSlc *token.Token, in *input.Input) (tokens []*token.Token, err error) {
 	identifier := ct.readIdentifier(in)
 	if identifier.Len() == 0 {
+		// Not a macro after all, so the "." is left as content
+		in.Unshift('.')
 		return []*token.Token{lozSlc}, nil
 	}
 	in.UnshiftSlice(identifier)
@@ -198,7 +295,7 @@ func (ct *ContentTokenizer) parseMacroIdentifier(lozSlc *token.Token, in *input.
 	if found {
 		tokens = []*token.Token{token.NewToken(token.TTmacro, identifier)}
 		var nextTokens []*token.Token
-		nextTokens, err = m.NextTokens(ct, in)
+		nextTokens, err = ct.macroNextTokens(m, in)
 		if err != nil {
 			return nil, err
 		}
@@ -206,11 +303,48 @@ func (ct *ContentTokenizer) parseMacroIdentifier(lozSlc *token.Token, in *input.
 			tokens = append(tokens, nextToken)
 		}
 	} else {
-		return nil, fmt.Errorf("unknown macro %q", identifier.S)
+		return nil, in.UnknownError("macro", identifier, ct.macros.Known())
 	}
 	return
 }
 
+// SetTokenTypes sets the registry macros look up their token types in. Until
+// it's set, the ContentTokenizer uses one of its own.
+func (ct *ContentTokenizer) SetTokenTypes(r *token.Registry) {
+	ct.tokenTypes = r
+}
+
+func (ct *ContentTokenizer) TokenTypes(macro string) token.Namespace {
+	if ct.tokenTypes == nil {
+		ct.tokenTypes = token.NewRegistry()
+	}
+	return ct.tokenTypes.Namespace(macro)
+}
+
+func (ct *ContentTokenizer) DefineMacro(m interfaces.Macro) bool {
+	if ct.macros == nil {
+		ct.macros = interfaces.NewMacros()
+	}
+	if _, found := ct.macros.Get(m.Name()); found {
+		return false
+	}
+	ct.macros.Add(m)
+	return true
+}
+
+// macroNextTokens calls m.NextTokens, turning a panic into an error at the
+// macro's name
+func (ct *ContentTokenizer) macroNextTokens(m interfaces.Macro, in *input.Input) (toks []*token.Token, err error) {
+	startIdx := in.Pos().Idx
+	defer func() {
+		if r := recover(); r != nil {
+			in.Seek(startIdx)
+			toks, err = nil, in.ErrorHere(&errors.MacroPanic{Macro: m.Name(), Value: r})
+		}
+	}()
+	return m.NextTokens(ct, in)
+}
+
 func (ct *ContentTokenizer) ParseGoToClosingBrace(in *input.Input) ([]*token.Token, error) {
 	var tt token.TokenType
 	if in.Consume('^') {
@@ -254,14 +388,19 @@ func (ct *ContentTokenizer) ParseGoCodeFromTo(in *input.Input, tt token.TokenTyp
 		if escapeInQuote {
 			escapeInQuote = false
 		}
-		if last && openCloseCount > 0 {
-			return false, fmt.Errorf("did not find matched '%c'", close)
-		}
 		return true, nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	if !foundBalance {
+		// The input ran out first
+		start, end := goCode.Start.Idx, goCode.End.Idx
+		in.Seek(start)
+		return nil, in.ErrorAt(start, end, fmt.Errorf("did not find matched '%c'", close),
+			input.Label{From: start, To: start + utf8.RuneLen(open), Msg: "opened here"},
+			input.Label{From: end, To: end, Msg: "input ended here"})
+	}
 	var toks []*token.Token
 	if isExpr {
 		toks = append(toks, token.NewToken(tt, goCode))
@@ -296,3 +435,43 @@ func (ct *ContentTokenizer) readIdentifier(in *input.Input) input.Slice {
 	})
 	return ident
 }
+
+// readShortExpr reads the expression following a marker in its short form:
+// an identifier followed by any selectors, indexes and calls, such as
+// user.Name, items[0] or f(x).Y. A "." that isn't followed by an identifier
+// ends it, so the full stop in "Hello ◊name." is left as content.
+func (ct *ContentTokenizer) readShortExpr(in *input.Input) (input.Slice, error) {
+	ident := ct.readIdentifier(in)
+	if ident.Len() == 0 {
+		return ident, nil
+	}
+	start, end := ident.Start.Idx, ident.End.Idx
+	for {
+		r, found := in.Peek()
+		if !found {
+			return in.SliceAt(start, end), nil
+		}
+		switch r {
+		case '.':
+			in.Shift(r)
+			sel := ct.readIdentifier(in)
+			if sel.Len() == 0 {
+				in.Unshift(r)
+				return in.SliceAt(start, end), nil
+			}
+			end = sel.End.Idx
+		case '[', '(':
+			close := ']'
+			if r == '(' {
+				close = ')'
+			}
+			toks, err := ct.ParseGoCodeFromTo(in, token.TTcodeLocalExpr, r, close, true)
+			if err != nil {
+				return input.EmptySlice(), err
+			}
+			end = toks[0].Slc.End.Idx
+		default:
+			return in.SliceAt(start, end), nil
+		}
+	}
+}
@@ -473,7 +473,6 @@ DONE
 			################################################################################
 			Token.TT: TT.WS
 			Token.Slc: test:1 - "\t   "
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -494,7 +493,6 @@ DONE
 			################################################################################
 			Token.TT: TT.NL
 			Token.Slc: test:1 - "\n"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -513,7 +511,6 @@ DONE
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "foo"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -532,7 +529,6 @@ DONE
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "◊"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -551,7 +547,6 @@ DONE
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "◊"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -570,7 +565,6 @@ DONE
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "◊"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -589,7 +583,6 @@ DONE
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "◊"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -608,7 +601,6 @@ DONE
 			################################################################################
 			Token.TT: TT.CodeLocalExpr
 			Token.Slc: test:1 - "foo"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -627,7 +619,6 @@ DONE
 			################################################################################
 			Token.TT: TT.CodeLocalExpr
 			Token.Slc: test:1 - "foo"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -646,7 +637,6 @@ DONE
 			################################################################################
 			Token.TT: TT.CodeLocalExpr
 			Token.Slc: test:1 - "(1 + 2)"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -679,7 +669,6 @@ DONE
 			################################################################################
 			Token.TT: TT.CodeLocalBlock
 			Token.Slc: test:1 - " var foo, bar, baz := struct{a string}{\"}\\\"\"}, '}', '\\'' "
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -742,7 +731,6 @@ DONE
 			################################################################################
 			Token.TT: TT.CodeGlobalBlock
 			Token.Slc: test:1 - " import \"bar\" "
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -809,7 +797,6 @@ foo`[1:])
 			################################################################################
 			Token.TT: TT.Content
 			Token.Slc: test:1 - "◊"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -860,7 +847,6 @@ func TestContentTokenizer_NextTokenCodeUntilOpenBrace(t *testing.T) {
 			################################################################################
 			Token.TT: TT.CodeLocalBlock
 			Token.Slc: test:1 - "if strings.DeepEqual(v, []string{\"\\\"\", \"{\"}) {"
-			Token.E: 
 			################################################################################
 			# rest
 			################################################################################
@@ -927,11 +913,11 @@ func logTokens(c *ic.IC, tokens []*token.Token) {
 	type tokensTable struct {
 		TT token.TokenType
 		S  input.Slice
-		E  *any
+		E  token.Attrs
 	}
 	tt := make([]tokensTable, len(tokens))
 	for i, toks := range tokens {
-		tt[i] = tokensTable{toks.TT, toks.Slc, toks.E}
+		tt[i] = tokensTable{toks.TT, toks.Slc, toks.Attrs()}
 	}
 	c.PT(tt)
 }
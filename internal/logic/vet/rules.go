@@ -0,0 +1,283 @@
+package vet
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/errors"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+)
+
+// StrayMarker reports a marker followed by whitespace or the end of the
+// template. It's written as-is, but was most likely meant to start some code.
+type StrayMarker struct{}
+
+func (StrayMarker) Name() string { return "straymarker" }
+func (StrayMarker) Doc() string  { return "marker followed by nothing it could start" }
+
+func (StrayMarker) Check(p *interfaces.Pass) (diags []interfaces.Diagnostic) {
+	marker := string(p.Marker)
+	for _, tok := range p.Tokens {
+		if tok.TT != token.TTcontent {
+			continue
+		}
+		for off := 0; ; {
+			idx := strings.Index(tok.Slc.S[off:], marker)
+			if idx == -1 {
+				break
+			}
+			start := tok.Slc.Start.Idx + off + idx
+			off += idx + len(marker)
+			// An escaped marker is followed by a second one in the template,
+			// even though the token only holds the first
+			end := start + len(marker)
+			if end == p.Input.Len() || strings.Contains(" \t\r\n", p.Input.SliceAt(end, end+1).S) {
+				diags = append(diags, interfaces.Diagnostic{
+					Pos:         p.Input.PosAt(start),
+					Message:     fmt.Sprintf("%q is followed by nothing and written as-is; use %q for a literal one", marker, marker+marker),
+					Suggestions: []errors.Suggestion{{From: start, To: end, Text: marker + marker}},
+				})
+			}
+		}
+	}
+	return diags
+}
+
+// UnusedLoopVar reports a variable declared by a range ◊.for that its body
+// never uses. Counting loops are left alone, as they're often used to repeat
+// their body.
+type UnusedLoopVar struct{}
+
+func (UnusedLoopVar) Name() string { return "loopvar" }
+func (UnusedLoopVar) Doc() string  { return "range loop variable never used in the loop body" }
+
+func (UnusedLoopVar) Check(p *interfaces.Pass) (diags []interfaces.Diagnostic) {
+	ast.Inspect(p.Nodes, func(n ast.Node) bool {
+		f, isFor := n.(*ast.For)
+		if !isFor || !isRange(f.Head.S) {
+			return true
+		}
+		used := make(map[string]bool)
+		// The else branch is left out, as the loop's variables aren't set in
+		// it
+		ast.Inspect(f.Body, func(n ast.Node) bool {
+			for id := range idents(nodeCode(n)) {
+				used[id] = true
+			}
+			return true
+		})
+		for _, v := range f.Vars {
+			if !used[v] {
+				diags = append(diags, interfaces.Diagnostic{
+					Pos:     f.Head.Start,
+					Message: fmt.Sprintf("loop variable %q is never used in the loop body", v),
+				})
+			}
+		}
+		if f.Helper != "" && !used[f.Helper] {
+			diags = append(diags, interfaces.Diagnostic{
+				Pos:     f.Head.Start,
+				Message: fmt.Sprintf("loop helper %q is never used in the loop body", f.Helper),
+			})
+		}
+		return true
+	})
+	return diags
+}
+
+func isRange(head string) bool {
+	toks, _ := scan(head)
+	for _, tok := range toks {
+		if tok == gotoken.RANGE {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeCode returns the Go code n holds itself, ignoring its children
+func nodeCode(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Expr:
+		return n.Slc.S
+	case *ast.Code:
+		return n.Slc.S
+	case *ast.GlobalCode:
+		return n.Slc.S
+	case *ast.Branch:
+		return n.Head.S
+	case *ast.For:
+		return n.Head.S
+	case *ast.Call:
+		return n.Args.S
+	default:
+		return ""
+	}
+}
+
+// HTMLAttr reports an expression written inside an HTML attribute without
+// going through an escaping function such as html.EscapeString
+type HTMLAttr struct{}
+
+func (HTMLAttr) Name() string { return "htmlattr" }
+func (HTMLAttr) Doc() string  { return "unescaped expression inside an HTML attribute" }
+
+// inAttrRegex matches text ending part way through an attribute value
+var inAttrRegex = regexp.MustCompile(`<[a-zA-Z][^<>]*\s[a-zA-Z_:][-\w:.]*\s*=\s*("[^"]*|'[^']*|[^\s"'<>=]*)$`)
+
+func (HTMLAttr) Check(p *interfaces.Pass) (diags []interfaces.Diagnostic) {
+	// The text written so far, from the last tag opened
+	var tail string
+	ast.Inspect(p.Nodes, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Text:
+			tail += n.Slc.S
+			if idx := strings.LastIndexByte(tail, '<'); idx > 0 {
+				tail = tail[idx:]
+			}
+		case *ast.Expr:
+			if inAttrRegex.MatchString(tail) && !isEscaped(n.Slc.S) {
+				diags = append(diags, interfaces.Diagnostic{
+					Pos:     n.Slc.Start,
+					Message: fmt.Sprintf("expression %q is written into an HTML attribute without escaping", n.Slc.S),
+				})
+			}
+			// Stand in for the value so any following expressions are
+			// still seen as being inside the attribute
+			tail += "x"
+		}
+		return true
+	})
+	return diags
+}
+
+// isEscaped reports whether expr is a call to a function with Escape in its
+// name, such as html.EscapeString or url.QueryEscape
+func isEscaped(expr string) bool {
+	e, err := goparser.ParseExpr(expr)
+	if err != nil {
+		return false
+	}
+	for {
+		paren, isParen := e.(*goast.ParenExpr)
+		if !isParen {
+			break
+		}
+		e = paren.X
+	}
+	call, isCall := e.(*goast.CallExpr)
+	if !isCall {
+		return false
+	}
+	var name string
+	switch fun := call.Fun.(type) {
+	case *goast.Ident:
+		name = fun.Name
+	case *goast.SelectorExpr:
+		name = fun.Sel.Name
+	}
+	return strings.Contains(name, "Escape")
+}
+
+// GlobalMain reports global code declaring func main, which the generated
+// program already has
+type GlobalMain struct{}
+
+func (GlobalMain) Name() string { return "globalmain" }
+func (GlobalMain) Doc() string  { return "global code declaring func main" }
+
+func (GlobalMain) Check(p *interfaces.Pass) (diags []interfaces.Diagnostic) {
+	ast.Inspect(p.Nodes, func(n ast.Node) bool {
+		g, isGlobal := n.(*ast.GlobalCode)
+		if !isGlobal {
+			return true
+		}
+		toks, lits := scan(g.Slc.S)
+		for i := 0; i+2 < len(toks); i++ {
+			if toks[i] == gotoken.FUNC && toks[i+1] == gotoken.IDENT && lits[i+1] == "main" && toks[i+2] == gotoken.LPAREN {
+				diags = append(diags, interfaces.Diagnostic{
+					Pos:     g.Slc.Start,
+					Message: "global code declares func main, which clashes with the generated one",
+				})
+			}
+		}
+		return true
+	})
+	return diags
+}
+
+// UnreachableBranch reports an ◊.if branch that can never run, either because
+// an earlier branch has the same condition or because an earlier branch
+// always runs
+type UnreachableBranch struct{}
+
+func (UnreachableBranch) Name() string { return "unreachable" }
+func (UnreachableBranch) Doc() string  { return "◊.if branch that can never run" }
+
+var elsePrefixRegex = regexp.MustCompile(`^}\s*else\s*`)
+
+func (UnreachableBranch) Check(p *interfaces.Pass) (diags []interfaces.Diagnostic) {
+	ast.Inspect(p.Nodes, func(n ast.Node) bool {
+		ifNode, isIf := n.(*ast.If)
+		if !isIf {
+			return true
+		}
+		report := func(b *ast.Branch, why string) {
+			diags = append(diags, interfaces.Diagnostic{
+				Pos:     b.Head.Start,
+				Message: fmt.Sprintf("branch %q can never run; %s", strings.TrimSpace(b.Head.S), why),
+			})
+		}
+		seen := make(map[string]bool)
+		// Once a branch declares variables, later conditions may refer to
+		// them rather than to what earlier conditions saw
+		var always, shadowed bool
+		for _, b := range ifNode.Branches {
+			head := elsePrefixRegex.ReplaceAllString(strings.TrimSpace(b.Head.S), "")
+			if always {
+				report(b, "an earlier branch always runs")
+				continue
+			}
+			if head == "{" {
+				always = true
+				continue
+			}
+			stmt, ok := parseIf(head)
+			if !ok {
+				continue
+			}
+			if stmt.Init != nil {
+				shadowed = true
+				continue
+			}
+			cond := types.ExprString(stmt.Cond)
+			if !shadowed && seen[cond] {
+				report(b, "an earlier branch has the same condition")
+				continue
+			}
+			seen[cond] = true
+			always = cond == "true"
+		}
+		return true
+	})
+	return diags
+}
+
+// parseIf parses an if statement ending in `{`. ok is false if it doesn't
+// parse.
+func parseIf(head string) (stmt *goast.IfStmt, ok bool) {
+	src := "package p\nfunc _() {\n" + head + "\n}\n}\n"
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, false
+	}
+	stmt, ok = f.Decls[0].(*goast.FuncDecl).Body.List[0].(*goast.IfStmt)
+	return stmt, ok
+}
@@ -0,0 +1,54 @@
+// Package vet holds the rules run by `lozenge vet`, each looking for a
+// template mistake that would otherwise only show up in the generated code
+// or its output.
+package vet
+
+import (
+	goscanner "go/scanner"
+	gotoken "go/token"
+
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+)
+
+// Default returns the rules run when no others are asked for
+func Default() *interfaces.Rules {
+	rules := interfaces.NewRules()
+	rules.Add(StrayMarker{})
+	rules.Add(UnusedLoopVar{})
+	rules.Add(HTMLAttr{})
+	rules.Add(GlobalMain{})
+	rules.Add(UnreachableBranch{})
+	return rules
+}
+
+// scan returns the Go tokens in code along with their literals. Any code that
+// fails to scan is skipped, as the Go compiler will complain about it anyway.
+func scan(code string) (toks []gotoken.Token, lits []string) {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s goscanner.Scanner
+	s.Init(file, []byte(code), nil, 0)
+	for {
+		_, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			return toks, lits
+		}
+		if tok == gotoken.SEMICOLON && lit == "\n" {
+			continue
+		}
+		toks = append(toks, tok)
+		lits = append(lits, lit)
+	}
+}
+
+// idents returns the identifiers used in code
+func idents(code string) map[string]bool {
+	found := make(map[string]bool)
+	toks, lits := scan(code)
+	for i, tok := range toks {
+		if tok == gotoken.IDENT {
+			found[lits[i]] = true
+		}
+	}
+	return found
+}
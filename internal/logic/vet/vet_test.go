@@ -0,0 +1,147 @@
+package vet
+
+import (
+	"testing"
+
+	"github.com/BestFriendChris/go-ic/ic"
+	"github.com/BestFriendChris/lozenge_template/input"
+	"github.com/BestFriendChris/lozenge_template/interfaces"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_define"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_for"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_if"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_import"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/parser"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+)
+
+func TestRules(t *testing.T) {
+	t.Run("straymarker", func(t *testing.T) {
+		s := "◊ price\n◊◊ escaped\n◊(1) ok\nends with ◊"
+
+		c := ic.New(t)
+		printDiags(&c, check(t, StrayMarker{}, s))
+		c.Expect(`
+			1:1 "◊" is followed by nothing and written as-is; use "◊◊" for a literal one
+				suggest 0-3 "◊◊"
+			4:11 "◊" is followed by nothing and written as-is; use "◊◊" for a literal one
+				suggest 45-48 "◊◊"
+			`)
+	})
+	t.Run("loopvar", func(t *testing.T) {
+		s := `
+◊.for i, v := range vals {◊◊v◊}
+◊.for _, v := range vals {◊◊.if v > 0 {◊+◊}◊}
+◊.for k := range vals {◊-◊}
+◊.for i := 0; i < 3; i++ {◊-◊}
+◊.define card(n int) {◊◊n◊}
+◊.for _, v := range vals {◊◊.card(v)◊}
+◊.for[loop] _, v := range vals {◊◊v◊} else {◊none◊}
+◊.for[loop] _, v := range vals {◊◊v◊.if !loop.Last {◊,◊}◊}`[1:]
+
+		c := ic.New(t)
+		printDiags(&c, check(t, UnusedLoopVar{}, s))
+		c.Expect(`
+			1:5 loop variable "i" is never used in the loop body
+			3:5 loop variable "k" is never used in the loop body
+			7:5 loop helper "loop" is never used in the loop body
+			`)
+	})
+	t.Run("htmlattr", func(t *testing.T) {
+		s := `
+<a href="/users/◊(u.ID)/◊(u.Name)" title=◊title>◊name</a>
+<img alt='◊(html.EscapeString(alt))'>
+<p>◊body</p>`[1:]
+
+		c := ic.New(t)
+		printDiags(&c, check(t, HTMLAttr{}, s))
+		c.Expect(`
+			1:20 expression "(u.ID)" is written into an HTML attribute without escaping
+			1:30 expression "(u.Name)" is written into an HTML attribute without escaping
+			1:49 expression "title" is written into an HTML attribute without escaping
+			`)
+	})
+	t.Run("globalmain", func(t *testing.T) {
+		s := `
+◊^{
+func main() {}
+func (s server) main() {}
+func mainly() {}
+}`[1:]
+
+		c := ic.New(t)
+		printDiags(&c, check(t, GlobalMain{}, s))
+		c.Expect(`
+			2:1 global code declares func main, which clashes with the generated one
+			`)
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		s := `
+◊.if x > 1 {◊a◊} else if x>1 {◊b◊} else if x < 1 {◊c◊}
+◊.if true {◊a◊} else if x {◊b◊}
+◊.if y := f(); y {◊a◊} else if x {◊b◊} else if x {◊c◊}
+◊.if y := f(); y {◊a◊} else if y {◊b◊}`[1:]
+
+		c := ic.New(t)
+		printDiags(&c, check(t, UnreachableBranch{}, s))
+		c.Expect(`
+			1:22 branch "} else if x>1 {" can never run; an earlier branch has the same condition
+			2:21 branch "} else if x {" can never run; an earlier branch always runs
+			`)
+	})
+}
+
+func TestDefault(t *testing.T) {
+	rules := Default()
+
+	c := ic.New(t)
+	for _, name := range rules.Known() {
+		r, _ := rules.Get(name)
+		c.Printf("%-12s %s\n", name, r.Doc())
+	}
+	c.Expect(`
+		globalmain   global code declaring func main
+		htmlattr     unescaped expression inside an HTML attribute
+		loopvar      range loop variable never used in the loop body
+		straymarker  marker followed by nothing it could start
+		unreachable  ◊.if branch that can never run
+		`)
+}
+
+func check(t *testing.T, r interfaces.Rule, s string) []interfaces.Diagnostic {
+	t.Helper()
+	macros := interfaces.NewMacros()
+	macros.Add(macro_if.New())
+	macros.Add(macro_for.New())
+	macros.Add(macro_import.New())
+	macros.Add(macro_define.New())
+	tokenTypes := token.NewRegistry()
+	macros.RegisterTokenTypes(tokenTypes)
+
+	in := input.NewInput("test.◊", s)
+	ct := tokenizer.NewDefault(macros)
+	ct.SetTokenTypes(tokenTypes)
+	toks, err := ct.ReadAll(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks = tokenizer.Optimize(toks, false)
+	prs := parser.New(macros)
+	prs.SetTokenTypes(tokenTypes)
+	nodes, err := prs.ParseTree(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := interfaces.NewRules()
+	rules.Add(r)
+	return rules.Check(&interfaces.Pass{Input: in, Marker: '◊', Tokens: toks, Nodes: nodes})
+}
+
+func printDiags(c *ic.IC, diags []interfaces.Diagnostic) {
+	for _, d := range diags {
+		c.Printf("%d:%d %s\n", d.Pos.Row, d.Pos.Col, d.Message)
+		for _, sug := range d.Suggestions {
+			c.Printf("\tsuggest %d-%d %q\n", sug.From, sug.To, sug.Text)
+		}
+	}
+}
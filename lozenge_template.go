@@ -1,42 +1,53 @@
 package lozenge_template
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/BestFriendChris/lozenge_template/input"
 	"github.com/BestFriendChris/lozenge_template/interfaces"
 	"github.com/BestFriendChris/lozenge_template/internal/infra/go_format"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/ast"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_define"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_for"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_if"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_import"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_raw"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_sep"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/macro/macro_slot"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/parser"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/sourcemap"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/token"
 	"github.com/BestFriendChris/lozenge_template/internal/logic/tokenizer"
+	"github.com/BestFriendChris/lozenge_template/internal/logic/vet"
 )
 
 func New(overrideMacros *interfaces.Macros, config ParserConfig) *LozengeTemplate {
-	return &LozengeTemplate{
+	lt := &LozengeTemplate{
 		config:        config,
 		defaultMacros: defaultMacros(overrideMacros),
+		tokenTypes:    token.NewRegistry(),
 	}
+	lt.defaultMacros.RegisterTokenTypes(lt.tokenTypes)
+	return lt
 }
 
 type LozengeTemplate struct {
 	config        ParserConfig
 	defaultMacros *interfaces.Macros
+	tokenTypes    *token.Registry
 }
 
 func (lt *LozengeTemplate) Generate(h interfaces.TemplateHandler, in *input.Input) (goCode string, err error) {
-	macros := lt.defaultMacros.Merge(h.DefaultMacros())
-
-	ct := tokenizer.New(lt.config.Loz, macros)
+	macros := lt.macros(h)
 
 	var toks []*token.Token
-
-	toks, err = ct.ReadAll(in)
+	toks, err = lt.tokenize(macros, in)
 	if err != nil {
 		return "", err
 	}
-	toks = tokenizer.Optimize(toks, lt.config.TrimSpaces)
 
-	prs := parser.New(macros)
+	prs := lt.parser(macros, in)
 	_, err = prs.Parse(h, toks)
 
 	if err != nil {
@@ -51,10 +62,150 @@ func (lt *LozengeTemplate) Generate(h interfaces.TemplateHandler, in *input.Inpu
 	return go_format.Format(goCode)
 }
 
+// GenerateWithSourceMap is Generate, also returning a source map from the
+// formatted Go code back to the template. h must implement
+// interfaces.SegmentHandler.
+func (lt *LozengeTemplate) GenerateWithSourceMap(h interfaces.TemplateHandler, in *input.Input) (goCode string, sm *sourcemap.SourceMap, err error) {
+	sh, ok := h.(interfaces.SegmentHandler)
+	if !ok {
+		return "", nil, fmt.Errorf("handler %T is unable to list its segments", h)
+	}
+	goCode, err = lt.Generate(h, in)
+	if err != nil {
+		return "", nil, err
+	}
+	sm, err = sourcemap.Build(goCode, sh.Segments())
+	if err != nil {
+		return "", nil, err
+	}
+	return goCode, sm, nil
+}
+
+// GenerateFromReader is Generate for a template read from r. The template is
+// tokenized, parsed and written to h as it's read rather than all at once, so
+// only the handler's output grows with the size of the template.
+func (lt *LozengeTemplate) GenerateFromReader(h interfaces.TemplateHandler, name string, r io.Reader) (goCode string, err error) {
+	macros := lt.macros(h)
+	ct := lt.tokenizer(macros)
+	opt := tokenizer.NewOptimizer(lt.config.TrimSpaces)
+	in := input.NewReaderInput(name, r)
+	prs := lt.parser(macros, in)
+	parse := func(toks []*token.Token) error {
+		if len(toks) == 0 {
+			return nil
+		}
+		_, err := prs.Parse(h, toks)
+		return err
+	}
+
+	err = ct.Stream(in, func(group []*token.Token) error {
+		toks := opt.Push(group...)
+		// Everything up to the end of a macro is flushed, so the tokens
+		// handed to the parser never end part way through one
+		if len(group) > 0 && group[0].TT == token.TTmacro {
+			toks = append(toks, opt.Flush()...)
+		}
+		return parse(toks)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = parse(opt.Flush()); err != nil {
+		return "", err
+	}
+
+	goCode, err = h.Done()
+	if err != nil {
+		return "", err
+	}
+
+	return go_format.Format(goCode)
+}
+
+// Tokenize returns the tokens Generate would parse for in
+func (lt *LozengeTemplate) Tokenize(h interfaces.TemplateHandler, in *input.Input) ([]*token.Token, error) {
+	return lt.tokenize(lt.macros(h), in)
+}
+
+// ParseTree returns the tree Generate would write to h for in, without
+// writing it
+func (lt *LozengeTemplate) ParseTree(h interfaces.TemplateHandler, in *input.Input) ([]ast.Node, error) {
+	macros := lt.macros(h)
+	toks, err := lt.tokenize(macros, in)
+	if err != nil {
+		return nil, err
+	}
+	return lt.parser(macros, in).ParseTree(toks)
+}
+
+// Vet checks in for likely mistakes using the default rules. Any rules in
+// overrideRules are run too, replacing a default rule of the same name.
+func (lt *LozengeTemplate) Vet(h interfaces.TemplateHandler, in *input.Input, overrideRules *interfaces.Rules) ([]interfaces.Diagnostic, error) {
+	macros := lt.macros(h)
+	toks, err := lt.tokenize(macros, in)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := lt.parser(macros, in).ParseTree(toks)
+	if err != nil {
+		return nil, err
+	}
+	pass := &interfaces.Pass{
+		Input:  in,
+		Marker: lt.config.Loz,
+		Tokens: toks,
+		Nodes:  nodes,
+	}
+	return vet.Default().Merge(overrideRules).Check(pass), nil
+}
+
+// TokenTypes returns the registry naming the custom token types used by the
+// template's macros
+func (lt *LozengeTemplate) TokenTypes() *token.Registry {
+	return lt.tokenTypes
+}
+
+func (lt *LozengeTemplate) macros(h interfaces.TemplateHandler) *interfaces.Macros {
+	handlerMacros := h.DefaultMacros()
+	handlerMacros.RegisterTokenTypes(lt.tokenTypes)
+	return lt.defaultMacros.Merge(handlerMacros)
+}
+
+// tokenizer and parser return a ContentTokenizer and a parser using macros,
+// which look up their token types in the template's registry. The parser's
+// errors point into in.
+func (lt *LozengeTemplate) tokenizer(macros *interfaces.Macros) *tokenizer.ContentTokenizer {
+	ct := tokenizer.New(lt.config.Loz, macros)
+	ct.SetTokenTypes(lt.tokenTypes)
+	return ct
+}
+
+func (lt *LozengeTemplate) parser(macros *interfaces.Macros, in *input.Input) *parser.DefaultParser {
+	prs := parser.New(macros)
+	prs.SetTokenTypes(lt.tokenTypes)
+	prs.SetInput(in)
+	return prs
+}
+
+func (lt *LozengeTemplate) tokenize(macros *interfaces.Macros, in *input.Input) ([]*token.Token, error) {
+	ct := lt.tokenizer(macros)
+
+	toks, err := ct.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	return tokenizer.Optimize(toks, lt.config.TrimSpaces), nil
+}
+
 func defaultMacros(overrideMacros *interfaces.Macros) *interfaces.Macros {
 	macros := interfaces.NewMacros()
 	macros.Add(macro_if.New())
 	macros.Add(macro_for.New())
+	macros.Add(macro_import.New())
+	macros.Add(macro_raw.New())
+	macros.Add(macro_define.New())
+	macros.Add(macro_slot.New())
+	macros.Add(macro_sep.New())
 
 	macros = macros.Merge(overrideMacros)
 